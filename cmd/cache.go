@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/boltcache"
+	"snmpflapd/internal/repository/flapdb"
+	"snmpflapd/internal/repository/memcache"
+	"snmpflapd/internal/repository/rediscache"
+	"time"
+)
+
+const (
+	cacheBackendMySQL  = "mysql"
+	cacheBackendDB     = "db"
+	cacheBackendMemory = "memory"
+	cacheBackendBolt   = "bbolt"
+	cacheBackendRedis  = "redis"
+)
+
+// cachePolicy returns the cache policy from the config
+func cachePolicy() repository.CachePolicy {
+	return repository.CachePolicy{
+		HostnameTTL: time.Duration(config.CacheHostnameTTL) * time.Minute,
+		IfNameTTL:   time.Duration(config.CacheIfNameTTL) * time.Minute,
+		IfAliasTTL:  time.Duration(config.CacheIfAliasTTL) * time.Minute,
+		NegativeTTL: time.Duration(config.CacheNegativeTTL) * time.Minute,
+		StaleTTL:    time.Duration(config.CacheStaleTTL) * time.Minute,
+	}
+}
+
+// makeCache returns the configured cache backend with the memory tier in front of it
+func makeCache(ctx context.Context, connector *flapdb.Connector) (repository.Cache, error) {
+
+	policy := cachePolicy()
+	memCacheConfig := memcache.Config{
+		Size:   config.MemCacheSize,
+		Policy: policy,
+	}
+
+	var backend repository.Cache
+	switch config.CacheBackend {
+	case cacheBackendMySQL, cacheBackendDB:
+		backend = connector
+		memCacheConfig.Hold = memCacheSharedHold
+
+	case cacheBackendMemory:
+		memCache := memcache.New(nil, memCacheConfig)
+		go memCache.RunStatsLog(ctx, memCacheStatsInterval)
+		return memCache, nil
+
+	case cacheBackendBolt:
+		boltCache, err := boltcache.Open(boltcache.Config{
+			Path:   config.CacheBoltFile,
+			Policy: policy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		backend = boltCache
+
+	case cacheBackendRedis:
+		redisCache, err := rediscache.Open(rediscache.Config{
+			Address:  config.RedisAddress,
+			Password: config.RedisPassword,
+			DB:       config.RedisDB,
+			Policy:   policy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		backend = redisCache
+		memCacheConfig.Hold = memCacheSharedHold
+
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", config.CacheBackend)
+	}
+
+	// In-memory cache tier in front of the backend
+	if config.MemCacheSize == 0 {
+		return backend, nil
+	}
+	memCache := memcache.New(backend, memCacheConfig)
+	go memCache.RunStatsLog(ctx, memCacheStatsInterval)
+	return memCache, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"snmpflapd/internal/repository/flapdb"
+	"time"
+)
+
+// dbConfig returns the event DB settings from the config
+func dbConfig() *flapdb.Config {
+	policy := cachePolicy()
+	return &flapdb.Config{
+		CacheIfNameMinutes:   int(policy.Retention(policy.IfNameTTL) / time.Minute),
+		CacheIfAliasMinutes:  int(policy.Retention(policy.IfAliasTTL) / time.Minute),
+		CacheHostnameMinutes: int(policy.Retention(policy.HostnameTTL) / time.Minute),
+		Driver:               config.DBDriver,
+		Host:                 config.DBHost,
+		DBName:               config.DBName,
+		User:                 config.DBUser,
+		Password:             config.DBPassword,
+		Port:                 config.DBPort,
+		TLS: flapdb.TLSConfig{
+			Enabled:    config.DBTLS,
+			CA:         config.DBTLSCA,
+			Cert:       config.DBTLSCert,
+			Key:        config.DBTLSKey,
+			ServerName: config.DBTLSServerName,
+			SkipVerify: config.DBTLSSkipVerify,
+		},
+		ConnectTimeout:  time.Duration(config.DBConnectTimeout) * time.Second,
+		ReadTimeout:     time.Duration(config.DBReadTimeout) * time.Second,
+		WriteTimeout:    time.Duration(config.DBWriteTimeout) * time.Second,
+		MaxOpenConns:    config.DBMaxOpenConns,
+		MaxIdleConns:    config.DBMaxIdleConns,
+		ConnMaxLifetime: time.Duration(config.DBConnMaxLifetime) * time.Minute,
+		Params:          config.DBParams,
+		DedupWindow:     time.Duration(config.DedupWindow) * time.Second,
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"snmpflapd/internal/repository/eventbuffer"
+	"snmpflapd/internal/repository/flapdb"
+	"snmpflapd/internal/repository/spool"
+	"snmpflapd/internal/services/health"
+	"snmpflapd/internal/services/leader"
+	"snmpflapd/internal/services/linkevent"
+)
+
+// healthConfig returns what the health output reports: the database status, write buffer and spool depth,
+// events dropped by the full write buffer, duplicate traps dropped in process, duplicate events skipped
+// by the database and whether the instance is the leader
+func healthConfig(connector *flapdb.Connector, buffer *eventbuffer.Buffer, eventSpool *spool.Spool, dedup *linkevent.Deduplicator,
+	elector *leader.Elector) health.Config {
+
+	cfg := health.Config{
+		Checks: map[string]func(context.Context) error{
+			"db": connector.Ping,
+		},
+		Gauges: map[string]func() int64{
+			"duplicates":   dedup.Duplicates,
+			"dbDuplicates": connector.Duplicates,
+			"leader": func() int64 {
+				if elector.IsLeader() {
+					return 1
+				}
+				return 0
+			},
+		},
+	}
+
+	if buffer != nil {
+		cfg.Gauges["writeBuffer"] = func() int64 { return int64(buffer.Len()) }
+		cfg.Gauges["writeBufferDropped"] = buffer.Dropped
+	}
+
+	if eventSpool != nil {
+		cfg.Gauges["spoolDepth"] = func() int64 { return int64(eventSpool.Depth()) }
+		cfg.Gauges["spoolBytes"] = eventSpool.Bytes
+		cfg.Gauges["spoolRejected"] = eventSpool.Rejected
+	}
+	return cfg
+}
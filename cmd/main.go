@@ -8,10 +8,19 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"snmpflapd/internal/inventory"
+	"snmpflapd/internal/inventory/netbox"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/eventbuffer"
 	"snmpflapd/internal/repository/flapdb"
+	"snmpflapd/internal/repository/spool"
 	"snmpflapd/internal/services/dbcleanup"
+	"snmpflapd/internal/services/health"
+	"snmpflapd/internal/services/leader"
 	"snmpflapd/internal/services/linkevent"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,25 +33,112 @@ const (
 	defaultLogFilename    = "snmpflapd.log"
 	defaultListenAddress  = "0.0.0.0"
 	defaultListenPort     = 162
+	defaultDBDriver       = flapdb.DriverMySQL
 	defaultDBHost         = "127.0.0.1"
 	defaultDBUser         = "root"
 	defaultDBName         = "snmpflapd"
 	defaultDBPassword     = ""
 	defaultCommunity      = ""
 	// queueInterval          = 30
-	defaultCleanUpInterval = 60
+	defaultCleanUpInterval  = 60
+	defaultNetBoxInterval   = 60
+	defaultNetBoxCacheFile  = "netbox-cache.json"
+	defaultPrewarmInterval  = 360
+	defaultMemCacheSize     = 10000
+	memCacheStatsInterval   = time.Hour
+	memCacheSharedHold      = time.Minute
+	shutdownTimeout         = time.Minute
+	leaderCampaignInterval  = 10 * time.Second
+	leaderLockName          = "snmpflapd-maintenance"
+	defaultCacheBackend     = cacheBackendMySQL
+	defaultCacheHostnameTTL = 1440
+	defaultCacheIfNameTTL   = 1440
+	defaultCacheIfAliasTTL  = 360
+	defaultCacheNegativeTTL = 5
+	defaultCacheStaleTTL    = 1440
+	defaultCacheBoltFile    = "snmpflapd-cache.db"
+	defaultRedisAddress     = "127.0.0.1:6379"
+	defaultReEnrichInterval = 5
+	defaultReEnrichMaxAge   = 1440
+	defaultEnrichTimeout    = 2000
+	defaultWriteBufferSize  = 100
+	defaultWriteBufferFlush = 1000
+	defaultSpoolFile        = "snmpflapd-spool.jsonl"
+	defaultSpoolMaxSize     = 100
+	defaultSpoolReplay      = 10
+	defaultRollupHourlyDays = 90
+	defaultArchiveDir       = "."
+	defaultPartitionAhead   = 3
+	defaultDedupWindow      = 60
 )
 
 type Config struct {
-	LogFilename     string
-	ListenAddress   string
-	ListenPort      int
-	DBHost          string
-	DBName          string
-	DBUser          string
-	DBPassword      string
-	Community       string
-	CleanUpInterval int
+	LogFilename         string
+	ListenAddress       string
+	ListenPort          int
+	DBDriver            string
+	DBHost              string
+	DBPort              int
+	DBName              string
+	DBUser              string
+	DBPassword          string
+	DBTLS               bool
+	DBTLSCA             string
+	DBTLSCert           string
+	DBTLSKey            string
+	DBTLSServerName     string
+	DBTLSSkipVerify     bool
+	DBConnectTimeout    int
+	DBReadTimeout       int
+	DBWriteTimeout      int
+	DBMaxOpenConns      int
+	DBMaxIdleConns      int
+	DBConnMaxLifetime   int
+	DBParams            map[string]string
+	Community           string
+	CleanUpInterval     int
+	HostnameSources     []string
+	HostnameStripDomain bool
+	HostnameLowercase   bool
+	HostnameRewrite     []linkevent.HostnameRewrite
+	StaticHostnames     map[string]string
+	InventoryFile       string
+	NetBoxURL           string
+	NetBoxToken         string
+	NetBoxInterval      int
+	NetBoxCacheFile     string
+	PrewarmInterval     int
+	MemCacheSize        int
+	CacheBackend        string
+	CacheHostnameTTL    int
+	CacheIfNameTTL      int
+	CacheIfAliasTTL     int
+	CacheNegativeTTL    int
+	CacheStaleTTL       int
+	CacheBoltFile       string
+	RedisAddress        string
+	RedisPassword       string
+	RedisDB             int
+	ReEnrichInterval    int
+	ReEnrichMaxAge      int
+	EnrichTimeout       int
+	WriteBufferSize     int
+	WriteBufferFlush    int
+	SpoolFile           string
+	SpoolMaxSize        int
+	SpoolReplay         int
+	HealthListen        string
+	RetentionDays       int
+	RollupHourlyDays    int
+	ArchiveMode         string
+	ArchiveDir          string
+	PartitionBy         string
+	PartitionAhead      int
+	DedupWindow         int
+	InstanceName        string
+	IfAliasExtractors   []linkevent.IfAliasExtractor
+	Charset             string
+	DeviceCharsets      map[string]string
 }
 
 // flags
@@ -52,19 +148,44 @@ var (
 	flagVerbose        bool
 	flagConfigFilename string
 	flagVersion        bool
-	period             time.Duration = time.Hour * 6
 )
 
 var config = Config{
-	LogFilename:     defaultLogFilename,
-	ListenAddress:   defaultListenAddress,
-	ListenPort:      defaultListenPort,
-	DBHost:          defaultDBHost,
-	DBName:          defaultDBName,
-	DBUser:          defaultDBUser,
-	DBPassword:      defaultDBPassword,
-	Community:       defaultCommunity,
-	CleanUpInterval: defaultCleanUpInterval,
+	LogFilename:      defaultLogFilename,
+	ListenAddress:    defaultListenAddress,
+	ListenPort:       defaultListenPort,
+	DBDriver:         defaultDBDriver,
+	DBHost:           defaultDBHost,
+	DBName:           defaultDBName,
+	DBUser:           defaultDBUser,
+	DBPassword:       defaultDBPassword,
+	Community:        defaultCommunity,
+	CleanUpInterval:  defaultCleanUpInterval,
+	HostnameSources:  linkevent.DefaultHostnameSources,
+	NetBoxInterval:   defaultNetBoxInterval,
+	NetBoxCacheFile:  defaultNetBoxCacheFile,
+	PrewarmInterval:  defaultPrewarmInterval,
+	MemCacheSize:     defaultMemCacheSize,
+	CacheBackend:     defaultCacheBackend,
+	CacheHostnameTTL: defaultCacheHostnameTTL,
+	CacheIfNameTTL:   defaultCacheIfNameTTL,
+	CacheIfAliasTTL:  defaultCacheIfAliasTTL,
+	CacheNegativeTTL: defaultCacheNegativeTTL,
+	CacheStaleTTL:    defaultCacheStaleTTL,
+	CacheBoltFile:    defaultCacheBoltFile,
+	RedisAddress:     defaultRedisAddress,
+	ReEnrichInterval: defaultReEnrichInterval,
+	ReEnrichMaxAge:   defaultReEnrichMaxAge,
+	EnrichTimeout:    defaultEnrichTimeout,
+	WriteBufferSize:  defaultWriteBufferSize,
+	WriteBufferFlush: defaultWriteBufferFlush,
+	SpoolFile:        defaultSpoolFile,
+	SpoolMaxSize:     defaultSpoolMaxSize,
+	SpoolReplay:      defaultSpoolReplay,
+	RollupHourlyDays: defaultRollupHourlyDays,
+	ArchiveDir:       defaultArchiveDir,
+	PartitionAhead:   defaultPartitionAhead,
+	DedupWindow:      defaultDedupWindow,
 }
 
 func init() {
@@ -79,6 +200,11 @@ func init() {
 	readConfigFile(&flagConfigFilename)
 	readConfigEnv()
 
+	// Instances sharing the database are told apart by the hostname unless named
+	if config.InstanceName == "" {
+		config.InstanceName, _ = os.Hostname()
+	}
+
 }
 
 func main() {
@@ -91,6 +217,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "migrate" {
+		os.Exit(runMigrate(ctx, flag.Args()[1:]))
+	}
+
 	var err error
 
 	// Logging setup
@@ -103,45 +233,253 @@ func main() {
 	log.SetOutput(f)
 	log.Println("snmpflapd started")
 
-	connector, err := flapdb.MakeDB(&flapdb.Config{
-		Host:     config.DBHost,
-		DBName:   config.DBName,
-		User:     config.DBUser,
-		Password: config.DBPassword,
-	})
+	policy := cachePolicy()
+	connector, err := flapdb.MakeDB(dbConfig())
 	if err != nil {
 		fmt.Println(err)
 		log.Fatalln(err)
 	}
 	defer connector.Close()
 
-	// Periodic DB clean up
-	go dbcleanup.RunDBCleanUp(ctx, connector, period)
+	// An embedded database is kept up to date, there is nobody to run migrations by hand
+	if config.DBDriver == flapdb.DriverSQLite {
+		if _, err := connector.MigrateUp(ctx); err != nil {
+			fmt.Println(err)
+			log.Fatalln(err)
+		}
+	}
+
+	// Refuse to run against a schema this build doesn't know
+	if err := connector.CheckSchema(ctx); err != nil {
+		fmt.Println(err)
+		log.Fatalln(err)
+	}
+
+	cache, err := makeCache(ctx, connector)
+	if err != nil {
+		fmt.Println(err)
+		log.Fatalln(err)
+	}
+	if config.CacheBackend != cacheBackendMySQL && config.CacheBackend != cacheBackendDB {
+		defer cache.Close()
+	}
+
+	hostnameResolver, err := linkevent.NewHostnameResolver(linkevent.HostnameConfig{
+		Sources:     config.HostnameSources,
+		StripDomain: config.HostnameStripDomain,
+		Lowercase:   config.HostnameLowercase,
+		Rewrites:    config.HostnameRewrite,
+		Static:      config.StaticHostnames,
+	})
+	if err != nil {
+		fmt.Println(err)
+		log.Fatalln(err)
+	}
+
+	// The static inventory overrides NetBox
+	var inv inventory.Chain
+	if config.InventoryFile != "" {
+		staticInventory, err := inventory.Load(config.InventoryFile)
+		if err != nil {
+			fmt.Println(err)
+			log.Fatalln(err)
+		}
+		log.Printf("Inventory loaded: %d entries", staticInventory.Len())
+		inv = append(inv, staticInventory)
+	}
+
+	if config.NetBoxURL != "" {
+		netBox, err := netbox.New(netbox.Config{
+			URL:       config.NetBoxURL,
+			Token:     config.NetBoxToken,
+			CacheFile: config.NetBoxCacheFile,
+		})
+		if err != nil {
+			fmt.Println(err)
+			log.Fatalln(err)
+		}
+		go netBox.Run(ctx, time.Duration(config.NetBoxInterval)*time.Minute)
+		inv = append(inv, netBox)
+	}
 
+	ifAliasParser, err := linkevent.NewIfAliasParser(config.IfAliasExtractors)
+	if err != nil {
+		fmt.Println(err)
+		log.Fatalln(err)
+	}
+
+	charsets, err := linkevent.NewCharsets(linkevent.CharsetConfig{
+		Default: config.Charset,
+		Devices: config.DeviceCharsets,
+	})
+	if err != nil {
+		fmt.Println(err)
+		log.Fatalln(err)
+	}
+
+	linkEventConfig := &linkevent.Config{
+		Community:     config.Community,
+		Hostname:      hostnameResolver,
+		Inventory:     inv,
+		CachePolicy:   policy,
+		IfAlias:       ifAliasParser,
+		Charsets:      charsets,
+		Dedup:         linkevent.NewDeduplicator(time.Duration(config.DedupWindow) * time.Second),
+		Instance:      config.InstanceName,
+		EnrichTimeout: time.Duration(config.EnrichTimeout) * time.Millisecond,
+	}
+
+	// Link events failed to be written are spooled on disk and replayed once the database is back
+	var repo repository.Connector = connector
+	var eventSpool *spool.Spool
+	if config.SpoolFile != "" {
+		eventSpool, err = spool.Open(connector, spool.Config{
+			Path:     config.SpoolFile,
+			MaxBytes: int64(config.SpoolMaxSize) * 1024 * 1024,
+		})
+		if err != nil {
+			fmt.Println(err)
+			log.Fatalln(err)
+		}
+		defer eventSpool.Close()
+		go eventSpool.Run(ctx, time.Duration(config.SpoolReplay)*time.Second)
+		repo = eventSpool
+	}
+
+	// Link events are written in batches unless the write buffer is disabled
+	var buffer *eventbuffer.Buffer
+	if config.WriteBufferSize > 0 {
+		buffer = eventbuffer.New(repo, eventbuffer.Config{
+			Size:     config.WriteBufferSize,
+			Interval: time.Duration(config.WriteBufferFlush) * time.Millisecond,
+		})
+		go buffer.Run(ctx)
+		repo = buffer
+	}
+
+	// Instances sharing the database run maintenance jobs on the leader only
+	elector := leader.New(connector, leaderLockName)
+	elector.Campaign(ctx)
+	go elector.Run(ctx, leaderCampaignInterval)
+
+	if config.HealthListen != "" {
+		go health.Run(ctx, config.HealthListen, healthConfig(connector, buffer, eventSpool, linkEventConfig.Dedup, elector))
+	}
+
+	// Periodic DB clean up. A local cache backend is cleaned up by every instance.
+	var cacheElector *leader.Elector
+	if config.CacheBackend == cacheBackendMySQL || config.CacheBackend == cacheBackendDB {
+		cacheElector = elector
+	}
+	go dbcleanup.RunDBCleanUp(ctx, cache, cacheElector, time.Duration(config.CleanUpInterval)*time.Minute)
+
+	// Periodic rollups and purge of link events
+	retentionPolicy := dbcleanup.RetentionPolicy{
+		Events:      time.Duration(config.RetentionDays) * 24 * time.Hour,
+		Hourly:      time.Duration(config.RollupHourlyDays) * 24 * time.Hour,
+		Archive:     config.ArchiveMode,
+		ArchiveDir:  config.ArchiveDir,
+		DedupWindow: time.Duration(config.DedupWindow) * time.Second,
+	}
+	if err := retentionPolicy.Validate(); err != nil {
+		fmt.Println(err)
+		log.Fatalln(err)
+	}
+	go dbcleanup.RunRetention(ctx, connector, elector, retentionPolicy, time.Duration(config.CleanUpInterval)*time.Minute)
+
+	// Partitions of ports are created ahead of time, expired ones are dropped by the retention policy
+	if config.PartitionBy != flapdb.PartitionNone {
+		if config.PartitionBy != flapdb.PartitionByMonth && config.PartitionBy != flapdb.PartitionByDay {
+			msg := fmt.Sprintf("unknown partitionBy %q", config.PartitionBy)
+			fmt.Println(msg)
+			log.Fatalln(msg)
+		}
+		if config.DBDriver != flapdb.DriverMySQL {
+			msg := "partitionBy is supported by the mysql driver only"
+			fmt.Println(msg)
+			log.Fatalln(msg)
+		}
+		go dbcleanup.RunPartitionMaintenance(ctx, connector, elector, config.PartitionBy, config.PartitionAhead,
+			time.Duration(config.CleanUpInterval)*time.Minute)
+	}
+
+	// Periodic ifXTable walk of known devices
+	if config.PrewarmInterval > 0 {
+		go linkevent.RunCachePrewarm(ctx, cache, linkEventConfig, time.Duration(config.PrewarmInterval)*time.Minute)
+	}
+
+	// Periodic retry of events with missing hostname, ifName or ifAlias
+	if config.ReEnrichInterval > 0 {
+		go linkevent.RunReEnrichment(ctx, repo, cache, linkEventConfig, elector,
+			time.Duration(config.ReEnrichInterval)*time.Minute, time.Duration(config.ReEnrichMaxAge)*time.Minute)
+	}
+
+	// Handlers are tracked, so events being enriched are saved before the buffer is flushed on shutdown
+	var handlers sync.WaitGroup
 	tl := g.NewTrapListener()
 	tl.OnNewTrap = func(packet *g.SnmpPacket, addr *net.UDPAddr) {
 		if linkevent.IsLinkEvent(packet) {
-			go linkevent.LinkEventHandler(ctx, connector, packet, addr, config.Community)
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				linkevent.LinkEventHandler(ctx, repo, cache, packet, addr, linkEventConfig)
+			}()
 		}
 	}
 	tl.Params = g.Default
 
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+
 	listenSocket := fmt.Sprintf("%v:%v", config.ListenAddress, config.ListenPort)
-	tlErr := tl.Listen(listenSocket)
-	if tlErr != nil {
-		fmt.Println(tlErr)
-		log.Fatalln(tlErr)
+	tlErr := make(chan error, 1)
+	go func() {
+		tlErr <- tl.Listen(listenSocket)
+	}()
+
+	select {
+	case err := <-tlErr:
+		fmt.Println(err)
+		log.Fatalln(err)
+	case <-c:
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	<-c
+	// Close returns once the listener has stopped, so no handler is started after that
+	log.Println("snmpflapd stopping")
+	tl.Close()
+	if !waitHandlers(&handlers, shutdownTimeout) {
+		log.Printf("link events still enriched after %s are lost", shutdownTimeout)
+	}
+
+	// Buffered events are written before the connector is closed
+	if buffer != nil {
+		if err := buffer.Flush(context.Background()); err != nil {
+			log.Println("unable to flush link events:", err)
+		}
+	}
 
 	defer func() {
 		cancel()
 	}()
 }
 
+// waitHandlers waits for link event handlers, false if they haven't finished in time
+func waitHandlers(handlers *sync.WaitGroup, timeout time.Duration) bool {
+
+	done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func readConfigFile(file *string) {
 	if _, err := toml.DecodeFile(*file, &config); err != nil {
 		msg := fmt.Sprintf("%s not found. Suppose we're using environment variables", *file)
@@ -172,10 +510,40 @@ func readConfigEnv() {
 
 	}
 
+	if dbDriver, exists := os.LookupEnv("DBDRIVER"); exists {
+		config.DBDriver = dbDriver
+	}
+
 	if dbHost, exists := os.LookupEnv("DBHOST"); exists {
 		config.DBHost = dbHost
 	}
 
+	if dbPort, exists := os.LookupEnv("DBPORT"); exists {
+		if intPort, error := strconv.Atoi(dbPort); error != nil {
+			msg := "Wrong environment variable DBPORT"
+			fmt.Println(msg)
+			log.Fatalln(msg)
+
+		} else {
+			config.DBPort = intPort
+		}
+	}
+
+	if dbTLS, exists := os.LookupEnv("DBTLS"); exists {
+		if boolTLS, error := strconv.ParseBool(dbTLS); error != nil {
+			msg := "Wrong environment variable DBTLS"
+			fmt.Println(msg)
+			log.Fatalln(msg)
+
+		} else {
+			config.DBTLS = boolTLS
+		}
+	}
+
+	if dbTLSCA, exists := os.LookupEnv("DBTLS_CA"); exists {
+		config.DBTLSCA = dbTLSCA
+	}
+
 	if dbName, exists := os.LookupEnv("DBNAME"); exists {
 		config.DBName = dbName
 	}
@@ -192,6 +560,46 @@ func readConfigEnv() {
 		config.Community = community
 	}
 
+	if cacheBackend, exists := os.LookupEnv("CACHE_BACKEND"); exists {
+		config.CacheBackend = cacheBackend
+	}
+
+	if redisAddress, exists := os.LookupEnv("REDIS_ADDRESS"); exists {
+		config.RedisAddress = redisAddress
+	}
+
+	if redisPassword, exists := os.LookupEnv("REDIS_PASSWORD"); exists {
+		config.RedisPassword = redisPassword
+	}
+
+	if inventoryFile, exists := os.LookupEnv("INVENTORY_FILE"); exists {
+		config.InventoryFile = inventoryFile
+	}
+
+	if netBoxURL, exists := os.LookupEnv("NETBOX_URL"); exists {
+		config.NetBoxURL = netBoxURL
+	}
+
+	if netBoxToken, exists := os.LookupEnv("NETBOX_TOKEN"); exists {
+		config.NetBoxToken = netBoxToken
+	}
+
+	if hostnameSources, exists := os.LookupEnv("HOSTNAME_SOURCES"); exists {
+		config.HostnameSources = strings.Split(hostnameSources, ",")
+	}
+
+	if spoolFile, exists := os.LookupEnv("SPOOL_FILE"); exists {
+		config.SpoolFile = spoolFile
+	}
+
+	if healthListen, exists := os.LookupEnv("HEALTH_LISTEN"); exists {
+		config.HealthListen = healthListen
+	}
+
+	if instanceName, exists := os.LookupEnv("INSTANCE_NAME"); exists {
+		config.InstanceName = instanceName
+	}
+
 }
 
 // func logVerbose(s string) {
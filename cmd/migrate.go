@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"snmpflapd/internal/repository/flapdb"
+)
+
+const migrateUsage = "usage: snmpflapd [-f config] migrate up|down|status"
+
+// runMigrate runs the migrate subcommand and returns the exit code
+func runMigrate(ctx context.Context, args []string) int {
+
+	if len(args) != 1 {
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	connector, err := flapdb.MakeDB(dbConfig())
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer connector.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := connector.MigrateUp(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+	case "down":
+		reverted, err := connector.MigrateDown(ctx)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if reverted == nil {
+			fmt.Println("no migrations applied")
+		} else {
+			fmt.Printf("reverted %04d %s\n", reverted.Version, reverted.Name)
+		}
+
+	case "status":
+		states, err := connector.MigrationStatus(ctx)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		for _, state := range states {
+			status := "pending"
+			if state.Applied {
+				status = "applied"
+			}
+			fmt.Printf("%04d %-30s %s\n", state.Version, state.Name, status)
+		}
+
+	default:
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,28 @@
+package inventory
+
+import "net"
+
+// Chain is a list of providers, the first one that knows a device wins
+type Chain []Provider
+
+var _ Provider = Chain{}
+
+// Lookup returns the entry of the first provider that knows the device
+func (c Chain) Lookup(ip net.IP) (*Entry, bool) {
+	for _, p := range c {
+		if entry, ok := p.Lookup(ip); ok {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// LookupInterface returns the interface from the first provider that knows it
+func (c Chain) LookupInterface(ip net.IP, ifName string) (*Interface, bool) {
+	for _, p := range c {
+		if iface, ok := p.LookupInterface(ip, ifName); ok {
+			return iface, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,228 @@
+// Package inventory provides static per-device data: hostname overrides, site, role,
+// owner team and tags. Entries are keyed by an IP address or a CIDR prefix,
+// the most specific entry matching a device wins.
+package inventory
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Provider is a source of inventory data
+type Provider interface {
+	// Lookup returns the entry of a device
+	Lookup(ip net.IP) (*Entry, bool)
+
+	// LookupInterface returns an interface of a device by its name
+	LookupInterface(ip net.IP, ifName string) (*Interface, bool)
+}
+
+var _ Provider = &Inventory{}
+
+// Entry is a static inventory record
+type Entry struct {
+	Match          string   `yaml:"match"`
+	Hostname       string   `yaml:"hostname"`
+	Site           string   `yaml:"site"`
+	Role           string   `yaml:"role"`
+	Owner          string   `yaml:"owner"`
+	Tags           []string `yaml:"tags"`
+	DisablePolling bool     `yaml:"disablePolling"`
+}
+
+// Interface is an inventory record of a device interface
+type Interface struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Peer is the connected endpoint, as "device interface"
+	Peer string `json:"peer"`
+}
+
+// Inventory is a set of entries ready for lookups
+type Inventory struct {
+	entries []entry
+}
+
+type entry struct {
+	network *net.IPNet
+	Entry
+}
+
+// Load reads an inventory file. The format is chosen by the extension: .yaml, .yml or .csv
+func Load(filename string) (*Inventory, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		entries, err = readYAML(f)
+	case ".csv":
+		entries, err = readCSV(f)
+	default:
+		return nil, fmt.Errorf("unknown inventory format of %s", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return New(entries)
+}
+
+// New returns an Inventory of the entries
+func New(entries []Entry) (*Inventory, error) {
+
+	inv := &Inventory{}
+	for _, e := range entries {
+		network, err := parseMatch(e.Match)
+		if err != nil {
+			return nil, err
+		}
+		inv.entries = append(inv.entries, entry{network: network, Entry: e})
+	}
+
+	// The most specific prefixes go first
+	sort.SliceStable(inv.entries, func(i, j int) bool {
+		iOnes, _ := inv.entries[i].network.Mask.Size()
+		jOnes, _ := inv.entries[j].network.Mask.Size()
+		return iOnes > jOnes
+	})
+
+	return inv, nil
+}
+
+// Lookup returns the most specific entry matching the IP address
+func (inv *Inventory) Lookup(ip net.IP) (*Entry, bool) {
+
+	if inv == nil {
+		return nil, false
+	}
+
+	for _, e := range inv.entries {
+		if e.network.Contains(ip) {
+			found := e.Entry
+			return &found, true
+		}
+	}
+	return nil, false
+}
+
+// LookupInterface returns nothing, a static inventory has no interfaces
+func (inv *Inventory) LookupInterface(ip net.IP, ifName string) (*Interface, bool) {
+	return nil, false
+}
+
+// Len returns the number of entries
+func (inv *Inventory) Len() int {
+	if inv == nil {
+		return 0
+	}
+	return len(inv.entries)
+}
+
+// parseMatch turns an IP address or a CIDR prefix into a network
+func parseMatch(match string) (*net.IPNet, error) {
+
+	if strings.Contains(match, "/") {
+		_, network, err := net.ParseCIDR(match)
+		if err != nil {
+			return nil, err
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(match)
+	if ip == nil {
+		return nil, fmt.Errorf("wrong inventory match %q", match)
+	}
+
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func readYAML(r io.Reader) ([]Entry, error) {
+
+	var doc struct {
+		Devices []Entry `yaml:"devices"`
+	}
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return doc.Devices, nil
+}
+
+// readCSV reads entries with a header row.
+// Known columns are match, hostname, site, role, owner, tags and disablePolling; tags are separated by ';'
+func readCSV(r io.Reader) ([]Entry, error) {
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["match"]; !ok {
+		return nil, errors.New("no match column")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[strings.ToLower(name)]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	entries := make([]Entry, 0, len(records)-1)
+	for _, record := range records[1:] {
+		e := Entry{
+			Match:    field(record, "match"),
+			Hostname: field(record, "hostname"),
+			Site:     field(record, "site"),
+			Role:     field(record, "role"),
+			Owner:    field(record, "owner"),
+		}
+
+		for _, tag := range strings.Split(field(record, "tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				e.Tags = append(e.Tags, tag)
+			}
+		}
+
+		if disablePolling := field(record, "disablePolling"); disablePolling != "" {
+			if e.DisablePolling, err = strconv.ParseBool(disablePolling); err != nil {
+				return nil, fmt.Errorf("wrong disablePolling of %s: %w", e.Match, err)
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,123 @@
+package netbox
+
+import (
+	"net"
+	"snmpflapd/internal/inventory"
+	"strings"
+)
+
+// These are the parts of NetBox API objects snmpflapd is interested in
+
+type nested struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type tag struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type ipAddress struct {
+	Address string `json:"address"`
+}
+
+type device struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	PrimaryIP *ipAddress `json:"primary_ip"`
+	Site      *nested    `json:"site"`
+	// Role is device_role before NetBox 3.6
+	Role       *nested `json:"role"`
+	DeviceRole *nested `json:"device_role"`
+	Tenant     *nested `json:"tenant"`
+	Tags       []tag   `json:"tags"`
+}
+
+type endpoint struct {
+	Name   string  `json:"name"`
+	Device *nested `json:"device"`
+}
+
+type iface struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Device      nested `json:"device"`
+	// ConnectedEndpoint is replaced by ConnectedEndpoints since NetBox 3.3
+	ConnectedEndpoint  *endpoint  `json:"connected_endpoint"`
+	ConnectedEndpoints []endpoint `json:"connected_endpoints"`
+}
+
+// primaryIP returns the primary IP address without a prefix length
+func (d *device) primaryIP() string {
+	if d.PrimaryIP == nil {
+		return ""
+	}
+	ip, _, err := net.ParseCIDR(d.PrimaryIP.Address)
+	if err != nil {
+		ip = net.ParseIP(d.PrimaryIP.Address)
+	}
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func (d *device) entry(ip string) inventory.Entry {
+
+	e := inventory.Entry{
+		Match:    ip,
+		Hostname: d.Name,
+	}
+
+	if d.Site != nil {
+		e.Site = d.Site.Name
+	}
+
+	role := d.Role
+	if role == nil {
+		role = d.DeviceRole
+	}
+	if role != nil {
+		e.Role = role.Name
+	}
+
+	if d.Tenant != nil {
+		e.Owner = d.Tenant.Name
+	}
+
+	for _, t := range d.Tags {
+		name := t.Slug
+		if name == "" {
+			name = t.Name
+		}
+		e.Tags = append(e.Tags, name)
+	}
+
+	return e
+}
+
+func (i *iface) inventoryInterface() inventory.Interface {
+
+	endpoints := i.ConnectedEndpoints
+	if len(endpoints) == 0 && i.ConnectedEndpoint != nil {
+		endpoints = []endpoint{*i.ConnectedEndpoint}
+	}
+
+	var peers []string
+	for _, e := range endpoints {
+		peer := e.Name
+		if e.Device != nil {
+			peer = e.Device.Name + " " + e.Name
+		}
+		peers = append(peers, peer)
+	}
+
+	return inventory.Interface{
+		Name:        i.Name,
+		Description: i.Description,
+		Peer:        strings.Join(peers, ", "),
+	}
+}
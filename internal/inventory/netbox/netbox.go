@@ -0,0 +1,275 @@
+// Package netbox is an inventory provider that synchronises devices and interfaces
+// from the NetBox REST API. The last good snapshot is kept in memory and on disk,
+// so enrichment keeps working while the API is unavailable.
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"snmpflapd/internal/inventory"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	devicesPath    = "/api/dcim/devices/"
+	interfacesPath = "/api/dcim/interfaces/"
+	pageLimit      = 1000
+	defaultTimeout = time.Second * 30
+)
+
+// Config holds NetBox connection settings
+type Config struct {
+	// URL is the NetBox base URL, e.g. https://netbox.example.com
+	URL   string
+	Token string
+	// CacheFile keeps the last good snapshot between restarts, optional
+	CacheFile string
+	// Client is used for API requests, http.Client with defaultTimeout when nil
+	Client *http.Client
+}
+
+// Provider is an inventory.Provider backed by NetBox
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mx       sync.RWMutex
+	snapshot *snapshot
+}
+
+var _ inventory.Provider = &Provider{}
+
+// snapshot is the synchronised data, keyed by the device primary IP address
+type snapshot struct {
+	Time       time.Time                                 `json:"time"`
+	Devices    map[string]inventory.Entry                `json:"devices"`
+	Interfaces map[string]map[string]inventory.Interface `json:"interfaces"`
+}
+
+// New returns a Provider loaded from the cache file, if there is one
+func New(cfg Config) (*Provider, error) {
+
+	if cfg.URL == "" {
+		return nil, errors.New("NetBox URL is not set")
+	}
+
+	p := &Provider{cfg: cfg, client: cfg.Client}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	if cfg.CacheFile != "" {
+		if err := p.loadCache(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println("unable to load NetBox cache:", err)
+		}
+	}
+
+	return p, nil
+}
+
+// Run synchronises the inventory at once and then periodically until the context is done
+func (p *Provider) Run(ctx context.Context, period time.Duration) {
+	for {
+		if err := p.Sync(ctx); err != nil {
+			log.Println("NetBox sync failed, using cached inventory:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(period):
+		}
+	}
+}
+
+// Sync pulls devices and interfaces and replaces the snapshot.
+// The snapshot is left intact on error.
+func (p *Provider) Sync(ctx context.Context) error {
+
+	var devices []device
+	if err := p.fetchAll(ctx, devicesPath, &devices); err != nil {
+		return err
+	}
+
+	var interfaces []iface
+	if err := p.fetchAll(ctx, interfacesPath, &interfaces); err != nil {
+		return err
+	}
+
+	s := &snapshot{
+		Time:       time.Now(),
+		Devices:    map[string]inventory.Entry{},
+		Interfaces: map[string]map[string]inventory.Interface{},
+	}
+
+	deviceIPs := map[int]string{}
+	for _, d := range devices {
+		ip := d.primaryIP()
+		if ip == "" {
+			continue
+		}
+		deviceIPs[d.ID] = ip
+		s.Devices[ip] = d.entry(ip)
+	}
+
+	for _, i := range interfaces {
+		ip, ok := deviceIPs[i.Device.ID]
+		if !ok {
+			continue
+		}
+		if s.Interfaces[ip] == nil {
+			s.Interfaces[ip] = map[string]inventory.Interface{}
+		}
+		s.Interfaces[ip][i.Name] = i.inventoryInterface()
+	}
+
+	p.mx.Lock()
+	p.snapshot = s
+	p.mx.Unlock()
+
+	log.Printf("NetBox sync done: %d devices, %d interfaces", len(s.Devices), len(interfaces))
+
+	if p.cfg.CacheFile != "" {
+		if err := p.saveCache(s); err != nil {
+			log.Println("unable to save NetBox cache:", err)
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the entry of a device by its primary IP address
+func (p *Provider) Lookup(ip net.IP) (*inventory.Entry, bool) {
+
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	if p.snapshot == nil {
+		return nil, false
+	}
+	entry, ok := p.snapshot.Devices[ip.String()]
+	if !ok {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// LookupInterface returns an interface of a device by its name
+func (p *Provider) LookupInterface(ip net.IP, ifName string) (*inventory.Interface, bool) {
+
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	if p.snapshot == nil {
+		return nil, false
+	}
+	i, ok := p.snapshot.Interfaces[ip.String()][ifName]
+	if !ok {
+		return nil, false
+	}
+	return &i, true
+}
+
+// fetchAll reads every page of a list endpoint into results
+func (p *Provider) fetchAll(ctx context.Context, path string, results interface{}) error {
+
+	base, err := url.Parse(strings.TrimSuffix(p.cfg.URL, "/") + path)
+	if err != nil {
+		return err
+	}
+	query := base.Query()
+	query.Set("limit", fmt.Sprint(pageLimit))
+	base.RawQuery = query.Encode()
+
+	var all []json.RawMessage
+	next := base.String()
+	for next != "" {
+		var page struct {
+			Next    *string           `json:"next"`
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := p.get(ctx, next, &page); err != nil {
+			return err
+		}
+		all = append(all, page.Results...)
+
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+
+	raw, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, results)
+}
+
+func (p *Provider) get(ctx context.Context, url string, v interface{}) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.cfg.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("NetBox API %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *Provider) loadCache() error {
+
+	raw, err := os.ReadFile(p.cfg.CacheFile)
+	if err != nil {
+		return err
+	}
+
+	s := &snapshot{}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return err
+	}
+
+	p.mx.Lock()
+	p.snapshot = s
+	p.mx.Unlock()
+
+	log.Printf("NetBox cache loaded: %d devices, synchronised at %s", len(s.Devices), s.Time.Format(time.RFC3339))
+	return nil
+}
+
+// saveCache writes the snapshot to a temporary file and renames it, not to leave a broken cache behind
+func (p *Provider) saveCache(s *snapshot) error {
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.cfg.CacheFile + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.cfg.CacheFile)
+}
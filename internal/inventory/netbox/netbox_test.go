@@ -0,0 +1,155 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"snmpflapd/internal/inventory"
+	"sync/atomic"
+	"testing"
+)
+
+// standIn is a NetBox API stand-in serving devices in two pages
+type standIn struct {
+	*httptest.Server
+	failing int32
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) serve(w http.ResponseWriter, r *http.Request) {
+
+	if atomic.LoadInt32(&s.failing) != 0 {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("Authorization") != "Token secret" {
+		http.Error(w, "no token", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == devicesPath && r.URL.Query().Get("offset") == "":
+		fmt.Fprintf(w, `{"next": "%s%s?limit=1000&offset=1000", "results": [
+			{"id": 1, "name": "core-1", "primary_ip": {"address": "192.0.2.1/32"},
+			 "site": {"id": 1, "name": "DC1", "slug": "dc1"}, "role": {"id": 1, "name": "Core", "slug": "core"},
+			 "tenant": {"id": 1, "name": "NetOps", "slug": "netops"},
+			 "tags": [{"name": "Critical", "slug": "critical"}, {"name": "Lab"}]},
+			{"id": 2, "name": "no-ip"}
+		]}`, s.URL, devicesPath)
+	case r.URL.Path == devicesPath:
+		fmt.Fprint(w, `{"next": null, "results": [
+			{"id": 3, "name": "access-1", "primary_ip": {"address": "2001:db8::3/128"},
+			 "device_role": {"id": 2, "name": "Access", "slug": "access"}}
+		]}`)
+	case r.URL.Path == interfacesPath:
+		fmt.Fprint(w, `{"next": null, "results": [
+			{"id": 10, "name": "Gi0/1", "description": "uplink", "device": {"id": 1, "name": "core-1"},
+			 "connected_endpoints": [{"name": "Gi1/0/48", "device": {"id": 3, "name": "access-1"}}]},
+			{"id": 11, "name": "Gi0/2", "device": {"id": 1, "name": "core-1"},
+			 "connected_endpoint": {"name": "eth0"}},
+			{"id": 12, "name": "Gi0/3", "device": {"id": 2, "name": "no-ip"}}
+		]}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSync(t *testing.T) {
+
+	api := newStandIn(t)
+	p, err := New(Config{URL: api.URL + "/", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := []struct {
+		ip   string
+		want inventory.Entry
+	}{
+		{"192.0.2.1", inventory.Entry{Match: "192.0.2.1", Hostname: "core-1", Site: "DC1", Role: "Core",
+			Owner: "NetOps", Tags: []string{"critical", "Lab"}}},
+		{"2001:db8::3", inventory.Entry{Match: "2001:db8::3", Hostname: "access-1", Role: "Access"}},
+	}
+	for _, tt := range devices {
+		got, ok := p.Lookup(net.ParseIP(tt.ip))
+		if !ok {
+			t.Errorf("Lookup(%s) found nothing", tt.ip)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Lookup(%s) = %+v, want %+v", tt.ip, *got, tt.want)
+		}
+	}
+
+	interfaces := []struct {
+		ip, name string
+		want     *inventory.Interface
+	}{
+		{"192.0.2.1", "Gi0/1", &inventory.Interface{Name: "Gi0/1", Description: "uplink", Peer: "access-1 Gi1/0/48"}},
+		{"192.0.2.1", "Gi0/2", &inventory.Interface{Name: "Gi0/2", Peer: "eth0"}},
+		{"192.0.2.1", "Gi0/9", nil},
+		{"2001:db8::3", "Gi0/1", nil},
+	}
+	for _, tt := range interfaces {
+		got, ok := p.LookupInterface(net.ParseIP(tt.ip), tt.name)
+		if tt.want == nil {
+			if ok {
+				t.Errorf("LookupInterface(%s, %s) = %+v, want nothing", tt.ip, tt.name, *got)
+			}
+			continue
+		}
+		if !ok || *got != *tt.want {
+			t.Errorf("LookupInterface(%s, %s) = %+v, %v, want %+v", tt.ip, tt.name, got, ok, *tt.want)
+		}
+	}
+}
+
+func TestSyncFailureKeepsCache(t *testing.T) {
+
+	api := newStandIn(t)
+	cfg := Config{URL: api.URL, Token: "secret", CacheFile: filepath.Join(t.TempDir(), "netbox.json")}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&api.failing, 1)
+	if err := p.Sync(context.Background()); err == nil {
+		t.Fatal("Sync succeeded against a failing API")
+	}
+
+	// A restarted instance is served from the cache file
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Sync(context.Background()); err == nil {
+		t.Fatal("Sync succeeded against a failing API")
+	}
+
+	for name, provider := range map[string]*Provider{"running": p, "restarted": restarted} {
+		if entry, ok := provider.Lookup(net.ParseIP("192.0.2.1")); !ok || entry.Hostname != "core-1" {
+			t.Errorf("%s: Lookup = %+v, %v, want core-1", name, entry, ok)
+		}
+		if i, ok := provider.LookupInterface(net.ParseIP("192.0.2.1"), "Gi0/1"); !ok || i.Peer != "access-1 Gi1/0/48" {
+			t.Errorf("%s: LookupInterface = %+v, %v, want the uplink", name, i, ok)
+		}
+	}
+}
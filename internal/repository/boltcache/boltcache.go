@@ -0,0 +1,239 @@
+// Package boltcache is a repository.Cache stored in an embedded bbolt file,
+// so cached values survive restarts without a database.
+package boltcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const openTimeout = time.Second * 5
+
+var (
+	bucketHostname = []byte("hostname")
+	bucketIfName   = []byte("ifname")
+	bucketIfAlias  = []byte("ifalias")
+)
+
+// Config holds the cache settings
+type Config struct {
+	Path   string
+	Policy repository.CachePolicy
+}
+
+// Cache is a repository.Cache stored in a bbolt file
+type Cache struct {
+	db *bolt.DB
+	// retention of values by bucket name
+	retention map[string]time.Duration
+}
+
+var _ repository.Cache = &Cache{}
+
+// Open opens or creates the cache file
+func Open(cfg Config) (*Cache, error) {
+
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketHostname, bucketIfName, bucketIfAlias} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Cache{
+		db: db,
+		retention: map[string]time.Duration{
+			string(bucketHostname): cfg.Policy.Retention(cfg.Policy.HostnameTTL),
+			string(bucketIfName):   cfg.Policy.Retention(cfg.Policy.IfNameTTL),
+			string(bucketIfAlias):  cfg.Policy.Retention(cfg.Policy.IfAliasTTL),
+		},
+	}, nil
+}
+
+// CleanUp deletes expired values
+func (c *Cache) CleanUp(ctx context.Context) error {
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketHostname, bucketIfName, bucketIfAlias} {
+			expired := time.Now().Add(-c.retention[string(bucket)])
+			b := tx.Bucket(bucket)
+
+			var keys [][]byte
+			cursor := b.Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if storedAt, _ := decode(v); storedAt.Before(expired) {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+			}
+			if err := deleteKeys(b, keys); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the cache file
+func (c *Cache) Close() {
+	c.db.Close()
+}
+
+func (c *Cache) GetCachedIfName(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get(bucketIfName, interfaceKey(m.IpAddress, m.IfIndex))
+}
+
+func (c *Cache) PutCachedIfName(ctx context.Context, m *flapdb.Model) error {
+	return c.put(bucketIfName, interfaceKey(m.IpAddress, m.IfIndex), m.IfName)
+}
+
+func (c *Cache) GetCachedIfAlias(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get(bucketIfAlias, interfaceKey(m.IpAddress, m.IfIndex))
+}
+
+func (c *Cache) PutCachedIfAlias(ctx context.Context, m *flapdb.Model) error {
+	return c.put(bucketIfAlias, interfaceKey(m.IpAddress, m.IfIndex), m.IfAlias)
+}
+
+func (c *Cache) GetCachedHostname(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get(bucketHostname, []byte(m.IpAddress.String()))
+}
+
+func (c *Cache) PutCachedHostname(ctx context.Context, m *flapdb.Model) error {
+	return c.put(bucketHostname, []byte(m.IpAddress.String()), m.HostName)
+}
+
+func (c *Cache) PutCachedIfNames(ctx context.Context, ip net.IP, ifNames map[int]string) error {
+	return c.replaceDevice(bucketIfName, ip, ifNames)
+}
+
+func (c *Cache) PutCachedIfAliases(ctx context.Context, ip net.IP, ifAliases map[int]string) error {
+	return c.replaceDevice(bucketIfAlias, ip, ifAliases)
+}
+
+func (c *Cache) get(bucket, key []byte) (*flapdb.CachedValue, error) {
+
+	var value *flapdb.CachedValue
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucket).Get(key)
+		if v == nil {
+			return repository.ErrCacheMiss
+		}
+
+		storedAt, s := decode(v)
+		age := time.Since(storedAt)
+		if age > c.retention[string(bucket)] {
+			return repository.ErrCacheMiss
+		}
+		value = &flapdb.CachedValue{Value: s, Age: age}
+		return nil
+	})
+
+	return value, err
+}
+
+func (c *Cache) put(bucket, key []byte, value *string) error {
+
+	if value == nil {
+		return nil
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, encode(time.Now(), *value))
+	})
+}
+
+// InvalidateDevice deletes cached ifNames and ifAliases of a device
+func (c *Cache) InvalidateDevice(ctx context.Context, ip net.IP) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteDevice(tx.Bucket(bucketIfName), ip); err != nil {
+			return err
+		}
+		return deleteDevice(tx.Bucket(bucketIfAlias), ip)
+	})
+}
+
+// replaceDevice deletes cached values of a device and stores the new ones in a single transaction
+func (c *Cache) replaceDevice(bucket []byte, ip net.IP, values map[int]string) error {
+
+	now := time.Now()
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		if err := deleteDevice(b, ip); err != nil {
+			return err
+		}
+
+		for ifIndex, value := range values {
+			if err := b.Put(interfaceKey(ip, ifIndex), encode(now, value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteDevice deletes every key of a device from the bucket
+func deleteDevice(b *bolt.Bucket, ip net.IP) error {
+
+	prefix := devicePrefix(ip)
+
+	// Keys are collected first, deleting with a cursor while iterating skips keys
+	var keys [][]byte
+	cursor := b.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	return deleteKeys(b, keys)
+}
+
+func deleteKeys(b *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode prepends a value with the time it is stored at
+func encode(storedAt time.Time, value string) []byte {
+	v := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(v, uint64(storedAt.UnixNano()))
+	copy(v[8:], value)
+	return v
+}
+
+func decode(v []byte) (time.Time, string) {
+	if len(v) < 8 {
+		return time.Time{}, ""
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), string(v[8:])
+}
+
+func devicePrefix(ip net.IP) []byte {
+	return []byte(ip.String() + "/")
+}
+
+func interfaceKey(ip net.IP, ifIndex int) []byte {
+	return []byte(fmt.Sprintf("%s/%d", ip, ifIndex))
+}
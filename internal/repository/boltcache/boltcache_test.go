@@ -0,0 +1,225 @@
+package boltcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	device = net.ParseIP("192.0.2.1")
+	// The keys of device would share a prefix with these without the "/" separator
+	neighbour = net.ParseIP("192.0.2.10")
+	ipv6      = net.ParseIP("2001:db8::1")
+	ipv6Long  = net.ParseIP("2001:db8::1:5")
+)
+
+func openCache(t *testing.T) *Cache {
+
+	c, err := Open(Config{
+		Path: filepath.Join(t.TempDir(), "cache.db"),
+		Policy: repository.CachePolicy{
+			HostnameTTL: time.Hour,
+			IfNameTTL:   time.Minute * 10,
+			IfAliasTTL:  time.Minute * 10,
+			StaleTTL:    time.Minute * 5,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// putAged stores a value as if it was stored age ago
+func putAged(t *testing.T, c *Cache, bucket, key []byte, value string, age time.Duration) {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, encode(time.Now().Add(-age), value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func checkIfName(t *testing.T, c *Cache, ip net.IP, ifIndex int, want string) {
+	t.Helper()
+	value, err := c.GetCachedIfName(&flapdb.Model{IpAddress: ip, IfIndex: ifIndex})
+	if want == "" {
+		if !errors.Is(err, repository.ErrCacheMiss) {
+			t.Errorf("%s/%d = %v, %v, want a cache miss", ip, ifIndex, value, err)
+		}
+		return
+	}
+	if err != nil || value.Value != want {
+		t.Errorf("%s/%d = %v, %v, want %q", ip, ifIndex, value, err, want)
+	}
+}
+
+func TestGetPut(t *testing.T) {
+
+	c := openCache(t)
+	ctx := context.Background()
+
+	m := &flapdb.Model{IpAddress: device, IfIndex: 3, HostName: stringPtr("core-1"), IfName: stringPtr("Gi0/3"), IfAlias: stringPtr("uplink")}
+	for _, put := range []func(context.Context, *flapdb.Model) error{c.PutCachedHostname, c.PutCachedIfName, c.PutCachedIfAlias} {
+		if err := put(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nil values are not stored
+	if err := c.PutCachedIfName(ctx, &flapdb.Model{IpAddress: device, IfIndex: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		get     func(*flapdb.Model) (*flapdb.CachedValue, error)
+		ifIndex int
+		want    string
+	}{
+		{"hostname", c.GetCachedHostname, 3, "core-1"},
+		{"ifName", c.GetCachedIfName, 3, "Gi0/3"},
+		{"ifAlias", c.GetCachedIfAlias, 3, "uplink"},
+		{"ifName of another interface", c.GetCachedIfName, 4, ""},
+	}
+	for _, tt := range tests {
+		value, err := tt.get(&flapdb.Model{IpAddress: device, IfIndex: tt.ifIndex})
+		if tt.want == "" {
+			if !errors.Is(err, repository.ErrCacheMiss) {
+				t.Errorf("%s: got %v, %v, want a cache miss", tt.name, value, err)
+			}
+			continue
+		}
+		if err != nil || value.Value != tt.want || value.Age > time.Minute {
+			t.Errorf("%s = %v, %v, want %q", tt.name, value, err, tt.want)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+
+	c := openCache(t)
+
+	// Stale values are kept until the TTL and the stale period have passed
+	putAged(t, c, bucketIfName, interfaceKey(device, 1), "stale", time.Minute*14)
+	putAged(t, c, bucketIfName, interfaceKey(device, 2), "expired", time.Minute*16)
+	putAged(t, c, bucketHostname, []byte(device.String()), "core-1", time.Minute*16)
+
+	value, err := c.GetCachedIfName(&flapdb.Model{IpAddress: device, IfIndex: 1})
+	if err != nil || value.Value != "stale" || value.Age < time.Minute*14 {
+		t.Errorf("stale ifName = %v, %v, want a value aged 14m", value, err)
+	}
+	checkIfName(t, c, device, 2, "")
+
+	if err := c.CleanUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketIfName).Get(interfaceKey(device, 2)) != nil {
+			t.Error("expired ifName is kept by CleanUp")
+		}
+		if tx.Bucket(bucketIfName).Get(interfaceKey(device, 1)) == nil {
+			t.Error("stale ifName is deleted by CleanUp")
+		}
+		if tx.Bucket(bucketHostname).Get([]byte(device.String())) == nil {
+			t.Error("hostname within its TTL is deleted by CleanUp")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaceDevice(t *testing.T) {
+
+	c := openCache(t)
+	ctx := context.Background()
+
+	for _, ip := range []net.IP{device, neighbour, ipv6, ipv6Long} {
+		if err := c.PutCachedIfNames(ctx, ip, map[int]string{1: "Gi0/1", 2: "Gi0/2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The devices have renumbered: ifIndex 2 is gone
+	for _, ip := range []net.IP{device, ipv6} {
+		if err := c.PutCachedIfNames(ctx, ip, map[int]string{1: "Gi0/2", 3: "Gi0/3"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ip      net.IP
+		ifIndex int
+		want    string
+	}{
+		{device, 1, "Gi0/2"},
+		{device, 2, ""},
+		{device, 3, "Gi0/3"},
+		{neighbour, 2, "Gi0/2"},
+		{ipv6, 2, ""},
+		{ipv6, 3, "Gi0/3"},
+		{ipv6Long, 2, "Gi0/2"},
+	}
+	for _, tt := range tests {
+		checkIfName(t, c, tt.ip, tt.ifIndex, tt.want)
+	}
+}
+
+func TestInvalidateDevice(t *testing.T) {
+
+	c := openCache(t)
+	ctx := context.Background()
+
+	for _, ip := range []net.IP{device, neighbour, ipv6, ipv6Long} {
+		m := &flapdb.Model{IpAddress: ip, IfIndex: 1, HostName: stringPtr("core-1"), IfName: stringPtr("Gi0/1"), IfAlias: stringPtr("uplink")}
+		for _, put := range []func(context.Context, *flapdb.Model) error{c.PutCachedHostname, c.PutCachedIfName, c.PutCachedIfAlias} {
+			if err := put(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, ip := range []net.IP{device, ipv6} {
+		if err := c.InvalidateDevice(ctx, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ip      net.IP
+		wantHit bool
+	}{
+		{device, false},
+		{neighbour, true},
+		{ipv6, false},
+		{ipv6Long, true},
+	}
+	for _, tt := range tests {
+		m := &flapdb.Model{IpAddress: tt.ip, IfIndex: 1}
+		for _, get := range []func(*flapdb.Model) (*flapdb.CachedValue, error){c.GetCachedIfName, c.GetCachedIfAlias} {
+			if _, err := get(m); (err == nil) != tt.wantHit {
+				t.Errorf("%s: %v, want a hit %v", tt.ip, err, tt.wantHit)
+			}
+		}
+		// Hostnames are not a part of the interface table
+		if _, err := c.GetCachedHostname(m); err != nil {
+			t.Errorf("hostname of %s: %v", tt.ip, err)
+		}
+	}
+}
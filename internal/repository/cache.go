@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net"
+	"snmpflapd/internal/repository/flapdb"
+	"time"
+)
+
+var _ Cache = &flapdb.Connector{}
+
+// ErrCacheMiss is returned by a Cache that has no valid value for a key
+var ErrCacheMiss = errors.New("cache miss")
+
+// CachePolicy describes how long cached values are used
+type CachePolicy struct {
+	HostnameTTL time.Duration
+	IfNameTTL   time.Duration
+	IfAliasTTL  time.Duration
+	// NegativeTTL is how long a failed lookup is not retried
+	NegativeTTL time.Duration
+	// StaleTTL is how long an expired value is still served while it is refreshed in the background
+	StaleTTL time.Duration
+}
+
+// Retention returns how long a value with the TTL has to be kept by a Cache, stale period included
+func (p CachePolicy) Retention(ttl time.Duration) time.Duration {
+	return ttl + p.StaleTTL
+}
+
+// Cache stores hostnames, ifNames and ifAliases received from devices
+type Cache interface {
+	// CleanUp deletes old cached values
+	CleanUp(ctx context.Context) error
+
+	// Close the cache storage
+	Close()
+
+	GetCachedIfName(*flapdb.Model) (*flapdb.CachedValue, error)
+
+	PutCachedIfName(context.Context, *flapdb.Model) error
+
+	GetCachedIfAlias(*flapdb.Model) (*flapdb.CachedValue, error)
+
+	PutCachedIfAlias(context.Context, *flapdb.Model) error
+
+	GetCachedHostname(*flapdb.Model) (*flapdb.CachedValue, error)
+
+	PutCachedHostname(context.Context, *flapdb.Model) error
+
+	// PutCachedIfNames replaces cached ifNames of a device, keyed by ifIndex
+	PutCachedIfNames(context.Context, net.IP, map[int]string) error
+
+	// PutCachedIfAliases replaces cached ifAliases of a device, keyed by ifIndex
+	PutCachedIfAliases(context.Context, net.IP, map[int]string) error
+
+	// InvalidateDevice deletes cached ifNames and ifAliases of a device
+	InvalidateDevice(context.Context, net.IP) error
+}
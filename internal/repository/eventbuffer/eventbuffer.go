@@ -0,0 +1,280 @@
+// Package eventbuffer is a write buffer in front of a repository.Connector.
+// Saved events are kept in memory and inserted with multi-row statements, flushed by size or interval,
+// so a trap handler never waits for the database. Updates of events still in the buffer are merged in place.
+package eventbuffer
+
+import (
+	"context"
+	"log"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxPendingBatches limits the buffer while the database is unavailable, in batches of Config.Size
+const maxPendingBatches = 100
+
+// Config holds the buffer settings
+type Config struct {
+	// Size is the number of events to flush at once
+	Size int
+	// Interval is the longest time an event waits in the buffer
+	Interval time.Duration
+}
+
+// Buffer is a repository.Connector buffering SaveLinkEvent
+type Buffer struct {
+	repository.Connector
+
+	size     int
+	interval time.Duration
+
+	mx      sync.Mutex
+	pending []*flapdb.Model
+	bySid   map[string]*flapdb.Model
+
+	// flushMx is held while a batch is written, so an update never overtakes the insert of its event
+	flushMx sync.Mutex
+	full    chan struct{}
+
+	// dropped counts events dropped since start, the buffer being full while writes fail
+	dropped int64
+}
+
+var _ repository.Connector = &Buffer{}
+
+// New returns a Buffer in front of the connector
+func New(connector repository.Connector, cfg Config) *Buffer {
+	return &Buffer{
+		Connector: connector,
+		size:      cfg.Size,
+		interval:  cfg.Interval,
+		bySid:     map[string]*flapdb.Model{},
+		full:      make(chan struct{}, 1),
+	}
+}
+
+// Run flushes the buffer every interval or once it is full, and for the last time when the context is done
+func (b *Buffer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			if err := b.Flush(context.Background()); err != nil {
+				log.Println("unable to flush link events:", err)
+			}
+			log.Println("closed due context")
+			return
+		case <-b.full:
+		case <-time.After(b.interval):
+		}
+
+		if err := b.Flush(ctx); err != nil {
+			log.Println("unable to flush link events:", err)
+		}
+	}
+}
+
+// SaveLinkEvent puts an event into the buffer
+func (b *Buffer) SaveLinkEvent(m *flapdb.Model) error {
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.pending = append(b.pending, m)
+	b.bySid[m.Sid] = m
+
+	if len(b.pending) >= b.size {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Len returns the number of events waiting in the buffer
+func (b *Buffer) Len() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return len(b.pending)
+}
+
+// Dropped returns the number of events dropped since start because the buffer was full while writes failed
+func (b *Buffer) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// UpdateLinkEvent merges the update into an event still in the buffer, or updates the stored event
+func (b *Buffer) UpdateLinkEvent(m *flapdb.Model) error {
+
+	if b.mergePending(m) {
+		return nil
+	}
+
+	// The event may be being inserted right now. It is back in the buffer if the insert has failed.
+	b.flushMx.Lock()
+	merged := b.mergePending(m)
+	b.flushMx.Unlock()
+	if merged {
+		return nil
+	}
+
+	return b.Connector.UpdateLinkEvent(m)
+}
+
+// mergePending merges the update into an event in the buffer and reports whether the event was there
+func (b *Buffer) mergePending(m *flapdb.Model) bool {
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	pending, ok := b.bySid[m.Sid]
+	if ok {
+		merge(pending, m)
+	}
+	return ok
+}
+
+// merge copies the fields set by UpdateLinkEvent
+func merge(pending, update *flapdb.Model) {
+	pending.HostName = update.HostName
+	pending.HostNameSource = update.HostNameSource
+	pending.IfName = update.IfName
+	pending.IfAlias = update.IfAlias
+	pending.LagIfIndex = update.LagIfIndex
+	pending.LagIfName = update.LagIfName
+	// The parent may have been set by MarkStackChildEvents of the parent's event, it is kept like the database does
+	if update.ParentIfIndex != nil {
+		pending.ParentIfIndex = update.ParentIfIndex
+	}
+	if update.ParentSid != nil {
+		pending.ParentSid = update.ParentSid
+	}
+	pending.IfDescription = update.IfDescription
+	pending.Peer = update.Peer
+	pending.Attributes = update.Attributes
+}
+
+// Flush inserts the buffered events. On failure they are kept for the next flush,
+// the oldest ones being dropped and counted once the buffer exceeds maxPendingBatches.
+func (b *Buffer) Flush(ctx context.Context) error {
+
+	b.flushMx.Lock()
+	defer b.flushMx.Unlock()
+
+	// Updates of the taken events wait for flushMx and go to the database
+	b.mx.Lock()
+	events := b.pending
+	b.pending = nil
+	for _, m := range events {
+		delete(b.bySid, m.Sid)
+	}
+	b.mx.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	err := b.Connector.SaveLinkEvents(ctx, events)
+	if err == nil {
+		return nil
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.pending = append(events, b.pending...)
+	if limit := b.size * maxPendingBatches; len(b.pending) > limit {
+		dropped := len(b.pending) - limit
+		b.pending = b.pending[dropped:]
+		atomic.AddInt64(&b.dropped, int64(dropped))
+		log.Printf("write buffer is full, %d link events dropped", dropped)
+	}
+	for _, m := range b.pending {
+		b.bySid[m.Sid] = m
+	}
+	return err
+}
+
+// GetIncompleteEvents flushes the buffer first, so buffered events are found
+func (b *Buffer) GetIncompleteEvents(ctx context.Context, since time.Time, afterSid string, limit int) ([]*flapdb.Model, error) {
+	if err := b.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return b.Connector.GetIncompleteEvents(ctx, since, afterSid, limit)
+}
+
+// GetStackParentEvent looks for the parent event in the buffer, then among the stored events
+func (b *Buffer) GetStackParentEvent(m *flapdb.Model, window time.Duration) (*string, error) {
+
+	if sid := b.pendingParent(m, window); sid != nil {
+		return sid, nil
+	}
+
+	// The parent may be being inserted right now. It is back in the buffer if the insert has failed.
+	b.flushMx.Lock()
+	sid := b.pendingParent(m, window)
+	b.flushMx.Unlock()
+	if sid != nil {
+		return sid, nil
+	}
+
+	return b.Connector.GetStackParentEvent(m, window)
+}
+
+// pendingParent returns sid of the latest buffered event matching the parent
+func (b *Buffer) pendingParent(parent *flapdb.Model, window time.Duration) *string {
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	var latest *flapdb.Model
+	for _, m := range b.pending {
+		if m.IfIndex != parent.IfIndex || !m.StackCorrelated(parent, window) {
+			continue
+		}
+		if latest == nil || m.Time.After(latest.Time) {
+			latest = m
+		}
+	}
+
+	if latest == nil {
+		return nil
+	}
+	sid := latest.Sid
+	return &sid
+}
+
+// MarkStackChildEvents links buffered child events to the parent event in place, then the stored ones
+func (b *Buffer) MarkStackChildEvents(ctx context.Context, parent *flapdb.Model, children []int, window time.Duration) error {
+
+	// Children being inserted right now are waited for, they are either stored or back in the buffer then
+	b.flushMx.Lock()
+	b.markPendingChildren(parent, children, window)
+	b.flushMx.Unlock()
+
+	return b.Connector.MarkStackChildEvents(ctx, parent, children, window)
+}
+
+// markPendingChildren links buffered events of the child interfaces to the parent event
+func (b *Buffer) markPendingChildren(parent *flapdb.Model, children []int, window time.Duration) {
+
+	isChild := make(map[int]bool, len(children))
+	for _, ifIndex := range children {
+		isChild[ifIndex] = true
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for _, m := range b.pending {
+		if !isChild[m.IfIndex] || m.ParentSid != nil || !m.StackCorrelated(parent, window) {
+			continue
+		}
+		parentIfIndex, parentSid := parent.IfIndex, parent.Sid
+		m.ParentIfIndex, m.ParentSid = &parentIfIndex, &parentSid
+	}
+}
@@ -0,0 +1,188 @@
+package eventbuffer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"testing"
+	"time"
+)
+
+// stored is a repository.Connector keeping saved events in memory
+type stored struct {
+	repository.Connector
+	events        []*flapdb.Model
+	parentQueries int
+	markQueries   int
+}
+
+func (s *stored) SaveLinkEvents(ctx context.Context, events []*flapdb.Model) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *stored) GetStackParentEvent(m *flapdb.Model, window time.Duration) (*string, error) {
+	s.parentQueries++
+	for _, e := range s.events {
+		if e.IfIndex == m.IfIndex && e.StackCorrelated(m, window) {
+			return &e.Sid, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *stored) MarkStackChildEvents(ctx context.Context, parent *flapdb.Model, children []int, window time.Duration) error {
+	s.markQueries++
+	return nil
+}
+
+var (
+	device = net.ParseIP("192.0.2.1")
+	flap   = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+)
+
+func event(sid string, ifIndex, ifOperStatus int, at time.Time) *flapdb.Model {
+	return &flapdb.Model{Sid: sid, IpAddress: device, IfIndex: ifIndex, IfAdminStatus: 1, IfOperStatus: ifOperStatus, Time: at}
+}
+
+func TestGetStackParentEvent(t *testing.T) {
+
+	db := &stored{}
+	b := New(db, Config{Size: 100, Interval: time.Minute})
+
+	_ = b.SaveLinkEvent(event("flushed", 10, 2, flap.Add(-time.Second*10)))
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*flapdb.Model{
+		event("parent-early", 1, 2, flap.Add(-time.Second*20)),
+		event("parent", 1, 7, flap.Add(-time.Second*5)),
+		event("parent-up", 1, 1, flap),
+		event("parent-late", 1, 2, flap.Add(time.Minute)),
+		event("other", 2, 2, flap),
+	} {
+		_ = b.SaveLinkEvent(m)
+	}
+
+	tests := []struct {
+		name        string
+		parent      *flapdb.Model
+		want        string
+		wantQueries int
+	}{
+		{"latest buffered event with the same stored status", event("child", 1, 2, flap), "parent", 0},
+		{"stored event", event("child", 10, 2, flap), "flushed", 1},
+		{"no parent", event("child", 3, 2, flap), "", 2},
+	}
+	for _, tt := range tests {
+		sid, err := b.GetStackParentEvent(tt.parent, time.Second*30)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: got %s, want no parent", tt.name, *sid)
+		case tt.want != "" && (err != nil || *sid != tt.want):
+			t.Errorf("%s: got %v, %v, want %s", tt.name, sid, err, tt.want)
+		}
+		if db.parentQueries != tt.wantQueries {
+			t.Errorf("%s: %d database queries, want %d", tt.name, db.parentQueries, tt.wantQueries)
+		}
+	}
+
+	if b.Len() != 5 {
+		t.Errorf("%d events in the buffer, want 5: lookups must not flush", b.Len())
+	}
+}
+
+func TestMarkStackChildEvents(t *testing.T) {
+
+	db := &stored{}
+	b := New(db, Config{Size: 100, Interval: time.Minute})
+
+	otherParent, otherParentIfIndex := "other-parent", 9
+	marked := event("marked", 11, 2, flap)
+	marked.ParentIfIndex, marked.ParentSid = &otherParentIfIndex, &otherParent
+
+	events := map[string]*flapdb.Model{
+		"child":          event("child", 11, 2, flap.Add(time.Second)),
+		"second-child":   event("second-child", 12, 2, flap.Add(-time.Second)),
+		"up":             event("up", 11, 1, flap),
+		"too-late":       event("too-late", 11, 2, flap.Add(time.Minute)),
+		"not-a-child":    event("not-a-child", 13, 2, flap),
+		"marked":         marked,
+		"another-device": {Sid: "another-device", IpAddress: net.ParseIP("192.0.2.2"), IfIndex: 11, IfOperStatus: 2, Time: flap},
+	}
+	for _, m := range events {
+		_ = b.SaveLinkEvent(m)
+	}
+
+	if err := b.MarkStackChildEvents(context.Background(), event("parent", 1, 2, flap), []int{11, 12}, time.Second*30); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"child": "parent", "second-child": "parent", "marked": "other-parent"}
+	for sid, m := range events {
+		got := ""
+		if m.ParentSid != nil {
+			got = *m.ParentSid
+		}
+		if got != want[sid] {
+			t.Errorf("parent of %s = %q, want %q", sid, got, want[sid])
+		}
+	}
+
+	if db.markQueries != 1 || len(db.events) != 0 {
+		t.Errorf("%d mark queries and %d events flushed, want stored events marked without a flush", db.markQueries, len(db.events))
+	}
+}
+
+func TestUpdateKeepsParent(t *testing.T) {
+
+	b := New(&stored{}, Config{Size: 100, Interval: time.Minute})
+
+	child := event("child", 11, 2, flap)
+	_ = b.SaveLinkEvent(child)
+	if err := b.MarkStackChildEvents(context.Background(), event("parent", 1, 2, flap), []int{11}, time.Second*30); err != nil {
+		t.Fatal(err)
+	}
+
+	ifName := "Gi0/11.100"
+	if err := b.UpdateLinkEvent(&flapdb.Model{Sid: "child", IfName: &ifName}); err != nil {
+		t.Fatal(err)
+	}
+
+	if child.IfName == nil || *child.IfName != ifName {
+		t.Errorf("ifName = %v, want %s", child.IfName, ifName)
+	}
+	if child.ParentSid == nil || *child.ParentSid != "parent" || child.ParentIfIndex == nil || *child.ParentIfIndex != 1 {
+		t.Errorf("parent = %v/%v, want the parent kept", child.ParentIfIndex, child.ParentSid)
+	}
+}
+
+// down is a repository.Connector failing every write
+type down struct {
+	repository.Connector
+}
+
+func (d *down) SaveLinkEvents(ctx context.Context, events []*flapdb.Model) error {
+	return errors.New("spool is full")
+}
+
+func TestDropped(t *testing.T) {
+
+	b := New(&down{}, Config{Size: 2, Interval: time.Minute})
+	limit := 2 * maxPendingBatches
+
+	for i := 0; i < limit+5; i++ {
+		_ = b.SaveLinkEvent(event(fmt.Sprint(i), 1, 2, flap))
+	}
+	if err := b.Flush(context.Background()); err == nil {
+		t.Fatal("Flush succeeded with the connector down")
+	}
+
+	if b.Len() != limit || b.Dropped() != 5 {
+		t.Errorf("%d events kept and %d dropped, want %d kept and 5 dropped", b.Len(), b.Dropped(), limit)
+	}
+}
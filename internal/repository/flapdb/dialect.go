@@ -0,0 +1,108 @@
+package flapdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// dialect adapts queries written for MySQL to the SQL database in use
+type dialect struct {
+	driver string
+	// replacer rewrites MySQL-only expressions, nil for MySQL
+	replacer *strings.Replacer
+}
+
+// postgresReplacer rewrites the MySQL-only expressions used by the queries of this package
+var postgresReplacer = strings.NewReplacer(
+	"TIMESTAMPDIFF(SECOND, time, now())", "CAST(EXTRACT(EPOCH FROM now() - time) AS bigint)",
+	"now() - INTERVAL ? MINUTE", "now() - make_interval(mins => ?)",
+)
+
+// sqliteReplacer rewrites the MySQL-only expressions for SQLite, which keeps local time as text
+var sqliteReplacer = strings.NewReplacer(
+	"TIMESTAMPDIFF(SECOND, time, now())", "(strftime('%s', 'now', 'localtime') - strftime('%s', time))",
+	"now() - INTERVAL ? MINUTE", "datetime('now', 'localtime', '-' || ? || ' minutes')",
+	"now()", "datetime('now', 'localtime')",
+)
+
+func init() {
+	sqlx.BindDriver(DriverSQLite, sqlx.QUESTION)
+}
+
+// newDialect returns the dialect of a driver, MySQL by default
+func newDialect(driver string) (*dialect, error) {
+	switch driver {
+	case "", DriverMySQL:
+		return &dialect{driver: DriverMySQL}, nil
+	case DriverPostgres:
+		return &dialect{driver: DriverPostgres, replacer: postgresReplacer}, nil
+	case DriverSQLite:
+		return &dialect{driver: DriverSQLite, replacer: sqliteReplacer}, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// setUp prepares a freshly opened database
+func (d *dialect) setUp(db *sqlx.DB) {
+
+	// SQLite has a single writer, concurrent connections would only get "database is locked"
+	if d.driver == DriverSQLite {
+		db.SetMaxOpenConns(1)
+	}
+}
+
+// setMapper makes sqlx map columns to struct fields the way the database names them.
+// PostgreSQL folds unquoted identifiers to lower case, so ifIndex comes back as ifindex.
+func (d *dialect) setMapper(db *sqlx.DB) {
+	if d.driver == DriverPostgres {
+		db.Mapper = reflectx.NewMapperTagFunc("db", strings.ToLower, strings.ToLower)
+	}
+}
+
+// ignoreDuplicates turns an INSERT statement into one skipping rows that violate a unique key
+func (d *dialect) ignoreDuplicates(insert string) string {
+	switch d.driver {
+	case DriverPostgres:
+		return strings.TrimSuffix(insert, ";") + " ON CONFLICT DO NOTHING;"
+	case DriverSQLite:
+		return strings.Replace(insert, "INSERT INTO", "INSERT OR IGNORE INTO", 1)
+	default:
+		return strings.Replace(insert, "INSERT INTO", "INSERT IGNORE INTO", 1)
+	}
+}
+
+// upsert turns an INSERT statement into one updating the columns of the row with the same unique key instead
+func (d *dialect) upsert(insert string, key, columns []string) string {
+
+	insert = strings.TrimSuffix(insert, ";")
+	set := make([]string, 0, len(columns))
+	if d.driver == DriverMySQL {
+		for _, column := range columns {
+			set = append(set, column+" = VALUES("+column+")")
+		}
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ") + ";"
+	}
+
+	for _, column := range columns {
+		set = append(set, column+" = EXCLUDED."+column)
+	}
+	return insert + " ON CONFLICT (" + strings.Join(key, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ") + ";"
+}
+
+// query rewrites a MySQL query for the database: expressions first, then ? placeholders
+func (c *Connector) query(q string) string {
+	if c.dialect.replacer != nil {
+		q = c.dialect.replacer.Replace(q)
+	}
+	return c.db.Rebind(q)
+}
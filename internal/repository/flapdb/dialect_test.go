@@ -0,0 +1,85 @@
+package flapdb
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const testInsert = `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now());`
+
+func TestIgnoreDuplicates(t *testing.T) {
+
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{DriverMySQL, `INSERT IGNORE INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now());`},
+		{DriverPostgres, `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now()) ON CONFLICT DO NOTHING;`},
+		{DriverSQLite, `INSERT OR IGNORE INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now());`},
+	}
+	for _, tt := range tests {
+		d, err := newDialect(tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.ignoreDuplicates(testInsert); got != tt.want {
+			t.Errorf("%s: ignoreDuplicates() = %s, want %s", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{DriverMySQL, `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now())` +
+			` ON DUPLICATE KEY UPDATE ifName = VALUES(ifName), time = VALUES(time);`},
+		{DriverPostgres, `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now())` +
+			` ON CONFLICT (ipaddress, ifIndex) DO UPDATE SET ifName = EXCLUDED.ifName, time = EXCLUDED.time;`},
+		{DriverSQLite, `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now())` +
+			` ON CONFLICT (ipaddress, ifIndex) DO UPDATE SET ifName = EXCLUDED.ifName, time = EXCLUDED.time;`},
+	}
+	for _, tt := range tests {
+		d, err := newDialect(tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.upsert(testInsert, cacheInterfaceKey, []string{"ifName", "time"}); got != tt.want {
+			t.Errorf("%s: upsert() = %s, want %s", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{DriverMySQL, selectIfNameWhereTime},
+		{DriverPostgres, "SELECT ifName AS value, CAST(EXTRACT(EPOCH FROM now() - time) AS bigint) AS age FROM cache_ifname" +
+			" WHERE time > now() - make_interval(mins => $1) AND ipaddress = $2 AND ifIndex = $3;"},
+		{DriverSQLite, "SELECT ifName AS value, (strftime('%s', 'now', 'localtime') - strftime('%s', time)) AS age FROM cache_ifname" +
+			" WHERE time > datetime('now', 'localtime', '-' || ? || ' minutes') AND ipaddress = ? AND ifIndex = ?;"},
+	}
+	for _, tt := range tests {
+		d, err := newDialect(tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Connector{db: sqlx.NewDb(nil, d.driver), dialect: d}
+		if got := c.query(selectIfNameWhereTime); got != tt.want {
+			t.Errorf("%s: query() = %s, want %s", tt.driver, got, tt.want)
+		}
+	}
+
+	if _, err := newDialect("oracle"); err == nil {
+		t.Error("newDialect accepted an unknown driver")
+	}
+	if d, err := newDialect(""); err != nil || d.driver != DriverMySQL {
+		t.Errorf("default dialect %v, %v, want MySQL", d, err)
+	}
+}
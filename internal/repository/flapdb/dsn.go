@@ -0,0 +1,191 @@
+package flapdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mysqlTLSConfigName is the name the TLS config is registered with the MySQL driver
+	mysqlTLSConfigName = "snmpflapd"
+
+	// sqliteBusyTimeout is how long SQLite waits for a lock held by another process, in milliseconds
+	sqliteBusyTimeout = 5000
+)
+
+// TLSConfig describes TLS of the database connection
+type TLSConfig struct {
+	Enabled bool
+	// CA is a PEM bundle to verify the server certificate with, the system roots if empty
+	CA string
+	// Cert and Key are a PEM client certificate and its key, for databases requiring one
+	Cert string
+	Key  string
+	// ServerName overrides the host name the server certificate is verified against. MySQL only,
+	// the PostgreSQL driver verifies the host it connects to.
+	ServerName string
+	// SkipVerify disables verification of the server certificate
+	SkipVerify bool
+}
+
+// dataSourceName returns the DSN of the driver
+func (d *dialect) dataSourceName(cfg *Config) (string, error) {
+	switch d.driver {
+	case DriverSQLite:
+		return sqliteDSN(cfg), nil
+	case DriverPostgres:
+		return postgresDSN(cfg)
+	default:
+		return mysqlDSN(cfg)
+	}
+}
+
+// address returns host:port, the port of Host wins
+func (cfg *Config) address(defaultPort int) string {
+
+	if _, _, err := net.SplitHostPort(cfg.Host); err == nil {
+		return cfg.Host
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+}
+
+func mysqlDSN(cfg *Config) (string, error) {
+
+	mysqlConfig := mysql.NewConfig()
+	mysqlConfig.User = cfg.User
+	mysqlConfig.Passwd = cfg.Password
+	mysqlConfig.Net = "tcp"
+	mysqlConfig.Addr = cfg.address(3306)
+	mysqlConfig.DBName = cfg.DBName
+	mysqlConfig.Timeout = cfg.ConnectTimeout
+	mysqlConfig.ReadTimeout = cfg.ReadTimeout
+	mysqlConfig.WriteTimeout = cfg.WriteTimeout
+	mysqlConfig.Params = map[string]string{"charset": "utf8mb4"}
+	for name, value := range cfg.Params {
+		mysqlConfig.Params[name] = value
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := cfg.TLS.tlsConfig()
+		if err != nil {
+			return "", err
+		}
+		if err := mysql.RegisterTLSConfig(mysqlTLSConfigName, tlsConfig); err != nil {
+			return "", err
+		}
+		mysqlConfig.TLSConfig = mysqlTLSConfigName
+	}
+
+	return mysqlConfig.FormatDSN(), nil
+}
+
+func postgresDSN(cfg *Config) (string, error) {
+
+	if cfg.TLS.Enabled && cfg.TLS.ServerName != "" {
+		return "", errors.New("TLS server name is not supported by the postgres driver, connect by the name in the certificate")
+	}
+
+	params := url.Values{}
+	params.Set("sslmode", "disable")
+	if cfg.TLS.Enabled {
+		switch {
+		case cfg.TLS.SkipVerify:
+			params.Set("sslmode", "require")
+		default:
+			params.Set("sslmode", "verify-full")
+		}
+		if cfg.TLS.CA != "" {
+			params.Set("sslrootcert", cfg.TLS.CA)
+		}
+		if cfg.TLS.Cert != "" {
+			params.Set("sslcert", cfg.TLS.Cert)
+		}
+		if cfg.TLS.Key != "" {
+			params.Set("sslkey", cfg.TLS.Key)
+		}
+	}
+	if cfg.ConnectTimeout > 0 {
+		params.Set("connect_timeout", strconv.Itoa(int(cfg.ConnectTimeout.Seconds())))
+	}
+	for name, value := range cfg.Params {
+		params.Set(name, value)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     cfg.address(5432),
+		Path:     "/" + cfg.DBName,
+		RawQuery: params.Encode(),
+	}
+	return dsn.String(), nil
+}
+
+// sqliteDSN uses DBName as the path of the database file
+func sqliteDSN(cfg *Config) string {
+
+	params := []string{
+		fmt.Sprintf("_pragma=busy_timeout(%d)", sqliteBusyTimeout),
+		"_pragma=journal_mode(WAL)",
+	}
+
+	names := make([]string, 0, len(cfg.Params))
+	for name := range cfg.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(cfg.Params[name]))
+	}
+
+	return "file:" + cfg.DBName + "?" + strings.Join(params, "&")
+}
+
+// tlsConfig loads the CA bundle and the client certificate
+func (t *TLSConfig) tlsConfig() (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.SkipVerify,
+	}
+
+	if t.CA != "" {
+		pem, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CA)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if t.Cert != "" || t.Key != "" {
+		if t.Cert == "" || t.Key == "" {
+			return nil, errors.New("both TLS client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,50 @@
+package flapdb
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPostgresDSN(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		tls     TLSConfig
+		want    map[string]string
+		wantErr bool
+	}{
+		{"no TLS", TLSConfig{}, map[string]string{"sslmode": "disable"}, false},
+		{"verified", TLSConfig{Enabled: true, CA: "/ca.pem"},
+			map[string]string{"sslmode": "verify-full", "sslrootcert": "/ca.pem"}, false},
+		{"not verified", TLSConfig{Enabled: true, SkipVerify: true}, map[string]string{"sslmode": "require"}, false},
+		{"client certificate", TLSConfig{Enabled: true, Cert: "/client.pem", Key: "/client-key.pem"},
+			map[string]string{"sslmode": "verify-full", "sslcert": "/client.pem", "sslkey": "/client-key.pem"}, false},
+		{"client certificate with the key inside", TLSConfig{Enabled: true, Cert: "/client.pem"},
+			map[string]string{"sslmode": "verify-full", "sslcert": "/client.pem"}, false},
+		{"server name", TLSConfig{Enabled: true, ServerName: "db.example.com"}, nil, true},
+	}
+	for _, tt := range tests {
+		dsn, err := postgresDSN(&Config{Host: "192.0.2.5", DBName: "snmpflapd", User: "flap", TLS: tt.tls})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := u.Query()
+		if len(params) != len(tt.want) {
+			t.Errorf("%s: params %v, want %v", tt.name, params, tt.want)
+		}
+		for name, value := range tt.want {
+			if params.Get(name) != value {
+				t.Errorf("%s: %s = %q, want %q", tt.name, name, params.Get(name), value)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package flapdb
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// mysqlDataErrors are MySQL errors caused by the values written rather than by the server
+var mysqlDataErrors = map[uint16]bool{
+	1048: true, // ER_BAD_NULL_ERROR
+	1062: true, // ER_DUP_ENTRY
+	1264: true, // ER_WARN_DATA_OUT_OF_RANGE
+	1265: true, // WARN_DATA_TRUNCATED
+	1292: true, // ER_TRUNCATED_WRONG_VALUE
+	1366: true, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD, e.g. an incorrect string value
+	1406: true, // ER_DATA_TOO_LONG
+	1451: true, // ER_ROW_IS_REFERENCED_2
+	1452: true, // ER_NO_REFERENCED_ROW_2
+	1690: true, // ER_DATA_OUT_OF_RANGE
+	3819: true, // ER_CHECK_CONSTRAINT_VIOLATED
+}
+
+// sqliteDataErrors are primary SQLite result codes caused by the values written
+var sqliteDataErrors = map[int]bool{
+	sqlite3.SQLITE_CONSTRAINT: true,
+	sqlite3.SQLITE_TOOBIG:     true,
+	sqlite3.SQLITE_MISMATCH:   true,
+	sqlite3.SQLITE_RANGE:      true,
+}
+
+// IsDataError reports whether the database has rejected the values written, e.g. a value too long for its column
+// or a constraint violation. Writing the same values again fails the same way, unlike after a connection error.
+func IsDataError(err error) bool {
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlDataErrors[mysqlErr.Number]
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 22 is data exception, class 23 is integrity constraint violation
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// Extended result codes keep the primary one in the lower byte
+		return sqliteDataErrors[sqliteErr.Code()&0xff]
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package flapdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"log"
+)
+
+// ErrLockLost is returned by Lock.Check when the lock is no longer held, e.g. the connection was broken
+var ErrLockLost = errors.New("lock lost")
+
+// Lock is an advisory lock of the database, held by a dedicated connection for as long as it is open.
+// MySQL and PostgreSQL release the lock of a broken connection, so another instance may take it over.
+type Lock struct {
+	name    string
+	dialect *dialect
+	// conn is nil for SQLite, whose database file is not shared by hosts
+	conn *sql.Conn
+}
+
+// TryLock takes the named advisory lock without waiting and returns nil if another session holds it.
+// The lock keeps a connection of its own, outside the query pool, until it is released.
+func (c *Connector) TryLock(ctx context.Context, name string) (*Lock, error) {
+
+	if c.dialect.driver == DriverSQLite {
+		return &Lock{name: name, dialect: c.dialect}, nil
+	}
+
+	conn, err := c.locks.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if c.dialect.driver == DriverPostgres {
+		err = conn.QueryRowContext(ctx, tryAdvisoryLockPostgres, lockKey(name)).Scan(&acquired)
+	} else {
+		err = conn.QueryRowContext(ctx, tryAdvisoryLockMySQL, name).Scan(&acquired)
+	}
+	if err != nil || !acquired {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Lock{name: name, dialect: c.dialect, conn: conn}, nil
+}
+
+// Check returns ErrLockLost if the lock is no longer held
+func (l *Lock) Check(ctx context.Context) error {
+
+	if l.conn == nil {
+		return nil
+	}
+
+	// A PostgreSQL advisory lock lives as long as the session, MySQL reports the owner of its locks
+	if l.dialect.driver == DriverPostgres {
+		if err := l.conn.PingContext(ctx); err != nil {
+			return ErrLockLost
+		}
+		return nil
+	}
+
+	var held bool
+	if err := l.conn.QueryRowContext(ctx, isAdvisoryLockHeldMySQL, l.name).Scan(&held); err != nil || !held {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release releases the lock and closes its connection
+func (l *Lock) Release(ctx context.Context) {
+
+	if l.conn == nil {
+		return
+	}
+
+	var err error
+	if l.dialect.driver == DriverPostgres {
+		_, err = l.conn.ExecContext(ctx, releaseAdvisoryLockPostgres, lockKey(l.name))
+	} else {
+		_, err = l.conn.ExecContext(ctx, releaseAdvisoryLockMySQL, l.name)
+	}
+	if err != nil {
+		log.Println("unable to release lock", l.name, err)
+	}
+
+	if err := l.conn.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+// lockKey maps a lock name to a PostgreSQL advisory lock key
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// MySQL locks are server wide, so they are named after the database
+const (
+	tryAdvisoryLockMySQL        = `SELECT COALESCE(GET_LOCK(CONCAT(DATABASE(), '.', ?), 0), 0) = 1;`
+	isAdvisoryLockHeldMySQL     = `SELECT COALESCE(IS_USED_LOCK(CONCAT(DATABASE(), '.', ?)) = CONNECTION_ID(), 0) = 1;`
+	releaseAdvisoryLockMySQL    = `DO RELEASE_LOCK(CONCAT(DATABASE(), '.', ?));`
+	tryAdvisoryLockPostgres     = `SELECT pg_try_advisory_lock($1);`
+	releaseAdvisoryLockPostgres = `SELECT pg_advisory_unlock($1);`
+)
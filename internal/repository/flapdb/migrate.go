@@ -0,0 +1,293 @@
+package flapdb
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are kept per driver as migrations/<driver>/<version>_<name>.up.sql and .down.sql.
+// Every driver has the same versions.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationState is a migration and whether it is applied to the database
+type MigrationState struct {
+	Migration
+	Applied bool
+}
+
+const (
+	createSchemaVersion = `CREATE TABLE IF NOT EXISTS schema_version (version int NOT NULL PRIMARY KEY,
+									name varchar(255) NOT NULL, applied varchar(32) NOT NULL);`
+	selectSchemaVersion = `SELECT COALESCE(MAX(version), 0) FROM schema_version;`
+	insertSchemaVersion = `INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?);`
+	deleteSchemaVersion = `DELETE FROM schema_version WHERE version = ?;`
+)
+
+// migrations returns the migrations of the driver sorted by version
+func (d *dialect) migrations() ([]Migration, error) {
+
+	dir := path.Join("migrations", d.driver)
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		name := file.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, migrationName, ok := strings.Cut(strings.TrimSuffix(name, "."+direction+".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("wrong migration file name %s", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("wrong migration file name %s: %w", name, err)
+		}
+
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// statements splits a migration into statements, dropping comment lines
+func statements(migration string) []string {
+
+	var lines []string
+	for _, line := range strings.Split(migration, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var result []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";\n") {
+		if statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";")); statement != "" {
+			result = append(result, statement)
+		}
+	}
+	return result
+}
+
+// LatestSchemaVersion returns the schema version this build works with
+func (c *Connector) LatestSchemaVersion() (int, error) {
+
+	migrations, err := c.dialect.migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion returns the version of the database schema, 0 for an empty database
+func (c *Connector) SchemaVersion(ctx context.Context) (int, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.schemaVersion(ctx)
+}
+
+func (c *Connector) schemaVersion(ctx context.Context) (int, error) {
+
+	if _, err := c.db.ExecContext(ctx, createSchemaVersion); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := c.db.GetContext(ctx, &version, selectSchemaVersion); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// CheckSchema returns an error unless the database schema is the one this build works with
+func (c *Connector) CheckSchema(ctx context.Context) error {
+
+	latest, err := c.LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	version, err := c.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version < latest:
+		return fmt.Errorf("database schema version %d is older than %d, run \"snmpflapd migrate up\"", version, latest)
+	case version > latest:
+		return fmt.Errorf("database schema version %d is newer than %d, upgrade snmpflapd", version, latest)
+	}
+	return nil
+}
+
+// MigrationStatus returns every migration and whether it is applied
+func (c *Connector) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+
+	migrations, err := c.dialect.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := c.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		states = append(states, MigrationState{Migration: m, Applied: m.Version <= version})
+	}
+	return states, nil
+}
+
+// MigrateUp applies every pending migration and returns the applied ones
+func (c *Connector) MigrateUp(ctx context.Context) ([]Migration, error) {
+
+	migrations, err := c.dialect.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	version, err := c.schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := c.applyMigration(ctx, m.up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, c.query(insertSchemaVersion), m.Version, m.Name, time.Now().Format("2006-01-02 15:04:05"))
+			return err
+		}); err != nil {
+			return applied, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Schema migrated up to version %d %s", m.Version, m.Name)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the latest applied migration and returns it, nil if nothing is applied
+func (c *Connector) MigrateDown(ctx context.Context) (*Migration, error) {
+
+	migrations, err := c.dialect.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	version, err := c.schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return nil, nil
+	}
+
+	for _, m := range migrations {
+		if m.Version != version {
+			continue
+		}
+		if err := c.applyMigration(ctx, m.down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, c.query(deleteSchemaVersion), m.Version)
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Schema migrated down from version %d %s", m.Version, m.Name)
+		return &m, nil
+	}
+
+	return nil, fmt.Errorf("database schema version %d is unknown to this build", version)
+}
+
+// applyMigration executes the statements of a migration and records it in one transaction.
+// MySQL commits DDL statements implicitly, so a failed MySQL migration may be applied partially.
+func (c *Connector) applyMigration(ctx context.Context, migration string, record func(*sql.Tx) error) error {
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	for _, statement := range statements(migration) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -1,5 +1,5 @@
-DROP TABLE IF EXISTS `ports`;
-CREATE TABLE `ports`
+-- The original schema, tables of existing databases are kept as they are
+CREATE TABLE IF NOT EXISTS `ports`
 (
     `id`            int(11) NOT NULL AUTO_INCREMENT,
     `sid`           char(50),
@@ -13,11 +13,12 @@ CREATE TABLE `ports`
     `ifAdminStatus` varchar(255) DEFAULT NULL,
     `ifOperStatus`  varchar(255) DEFAULT NULL,
     PRIMARY KEY (`id`),
-    KEY `time` (`time`)
+    KEY `time` (`time`),
+    KEY `idx_sid` (`sid`),
+    KEY `idx_time` (`time`)
 );
 
-DROP TABLE IF EXISTS `cache_hostname`;
-CREATE TABLE `cache_hostname`
+CREATE TABLE IF NOT EXISTS `cache_hostname`
 (
     `id`        int(11)  NOT NULL AUTO_INCREMENT,
     `time`      datetime NOT NULL default now(),
@@ -26,8 +27,7 @@ CREATE TABLE `cache_hostname`
     PRIMARY KEY (`id`)
 );
 
-DROP TABLE IF EXISTS `cache_ifname`;
-CREATE TABLE `cache_ifname`
+CREATE TABLE IF NOT EXISTS `cache_ifname`
 (
     `id`        int(11)      NOT NULL AUTO_INCREMENT,
     `time`      datetime     NOT NULL default now(),
@@ -37,8 +37,7 @@ CREATE TABLE `cache_ifname`
     PRIMARY KEY (`id`)
 );
 
-DROP TABLE IF EXISTS `cache_ifalias`;
-CREATE TABLE `cache_ifalias`
+CREATE TABLE IF NOT EXISTS `cache_ifalias`
 (
     `id`        int(11)      NOT NULL AUTO_INCREMENT,
     `time`      datetime     NOT NULL default now(),
@@ -47,5 +46,3 @@ CREATE TABLE `cache_ifalias`
     `ifAlias`   varchar(50)           DEFAULT NULL,
     PRIMARY KEY (`id`)
 );
-CREATE INDEX idx_sid USING btree ON ports (sid);
-CREATE INDEX idx_time USING btree ON ports (time);
@@ -0,0 +1,16 @@
+DROP INDEX idx_parent_sid ON ports;
+DROP INDEX idx_ipaddress_ifindex_time ON ports;
+DROP TABLE IF EXISTS `if_stack`;
+DROP TABLE IF EXISTS `lag_members`;
+ALTER TABLE `ports`
+    DROP COLUMN `hostnameSource`,
+    DROP COLUMN `lagIfIndex`,
+    DROP COLUMN `lagIfName`,
+    DROP COLUMN `parentIfIndex`,
+    DROP COLUMN `parentSid`,
+    DROP COLUMN `site`,
+    DROP COLUMN `role`,
+    DROP COLUMN `owner`,
+    DROP COLUMN `tags`,
+    DROP COLUMN `ifDescription`,
+    DROP COLUMN `peer`;
@@ -0,0 +1,37 @@
+ALTER TABLE `ports`
+    ADD COLUMN `hostnameSource` varchar(16)   DEFAULT NULL AFTER `hostname`,
+    ADD COLUMN `lagIfIndex`     int(8)        DEFAULT NULL,
+    ADD COLUMN `lagIfName`      varchar(255)  DEFAULT NULL,
+    ADD COLUMN `parentIfIndex`  int(8)        DEFAULT NULL,
+    ADD COLUMN `parentSid`      char(50)      DEFAULT NULL,
+    ADD COLUMN `site`           varchar(255)  DEFAULT NULL,
+    ADD COLUMN `role`           varchar(255)  DEFAULT NULL,
+    ADD COLUMN `owner`          varchar(255)  DEFAULT NULL,
+    ADD COLUMN `tags`           varchar(1024) DEFAULT NULL,
+    ADD COLUMN `ifDescription`  varchar(255)  DEFAULT NULL,
+    ADD COLUMN `peer`           varchar(255)  DEFAULT NULL;
+
+CREATE TABLE `lag_members`
+(
+    `id`         int(11)      NOT NULL AUTO_INCREMENT,
+    `time`       datetime     NOT NULL default now(),
+    `ipaddress`  varchar(255) NOT NULL,
+    `ifIndex`    int(8)       NOT NULL,
+    `lagIfIndex` int(8)       NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `ipaddress_ifIndex` (`ipaddress`, `ifIndex`)
+);
+
+CREATE TABLE `if_stack`
+(
+    `id`            int(11)      NOT NULL AUTO_INCREMENT,
+    `time`          datetime     NOT NULL default now(),
+    `ipaddress`     varchar(255) NOT NULL,
+    `higherIfIndex` int(8)       NOT NULL,
+    `lowerIfIndex`  int(8)       NOT NULL,
+    PRIMARY KEY (`id`),
+    KEY `ipaddress` (`ipaddress`)
+);
+
+CREATE INDEX idx_ipaddress_ifindex_time USING btree ON ports (ipaddress, ifIndex, time);
+CREATE INDEX idx_parent_sid USING btree ON ports (parentSid);
@@ -0,0 +1,10 @@
+CREATE TABLE `ports_attributes`
+(
+    `id`    int(11)      NOT NULL AUTO_INCREMENT,
+    `sid`   char(50)     NOT NULL,
+    `name`  varchar(64)  NOT NULL,
+    `value` varchar(255) NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `sid_name` (`sid`, `name`),
+    KEY `name_value` (`name`, `value`)
+) DEFAULT CHARSET = utf8mb4;
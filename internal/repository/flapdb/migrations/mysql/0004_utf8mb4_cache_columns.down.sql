@@ -0,0 +1,5 @@
+-- Character sets are kept, cached values longer than 50 characters are deleted
+DELETE FROM `cache_ifname` WHERE CHAR_LENGTH(`ifName`) > 50;
+DELETE FROM `cache_ifalias` WHERE CHAR_LENGTH(`ifAlias`) > 50;
+ALTER TABLE `cache_ifname` MODIFY `ifName` varchar(50) NOT NULL;
+ALTER TABLE `cache_ifalias` MODIFY `ifAlias` varchar(50) DEFAULT NULL;
@@ -0,0 +1,8 @@
+ALTER TABLE `ports` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `cache_hostname` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `cache_ifname` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `cache_ifalias` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `lag_members` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `if_stack` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `cache_ifname` MODIFY `ifName` varchar(255) NOT NULL;
+ALTER TABLE `cache_ifalias` MODIFY `ifAlias` varchar(255) DEFAULT NULL;
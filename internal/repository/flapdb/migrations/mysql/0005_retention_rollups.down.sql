@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS `ports_daily`;
+DROP TABLE IF EXISTS `ports_hourly`;
+DROP TABLE IF EXISTS `ports_attributes_archive`;
+DROP TABLE IF EXISTS `ports_archive`;
@@ -0,0 +1,62 @@
+CREATE TABLE `ports_archive`
+(
+    `id`             int(11)       NOT NULL,
+    `sid`            char(50),
+    `timeTicks`      bigint(12),
+    `time`           datetime      DEFAULT NULL,
+    `ipaddress`      varchar(255)  DEFAULT NULL,
+    `hostname`       varchar(255)  DEFAULT NULL,
+    `hostnameSource` varchar(16)   DEFAULT NULL,
+    `ifIndex`        int(8)        NOT NULL,
+    `ifName`         varchar(255)  DEFAULT NULL,
+    `ifAlias`        varchar(255)  DEFAULT NULL,
+    `ifAdminStatus`  varchar(255)  DEFAULT NULL,
+    `ifOperStatus`   varchar(255)  DEFAULT NULL,
+    `lagIfIndex`     int(8)        DEFAULT NULL,
+    `lagIfName`      varchar(255)  DEFAULT NULL,
+    `parentIfIndex`  int(8)        DEFAULT NULL,
+    `parentSid`      char(50)      DEFAULT NULL,
+    `site`           varchar(255)  DEFAULT NULL,
+    `role`           varchar(255)  DEFAULT NULL,
+    `owner`          varchar(255)  DEFAULT NULL,
+    `tags`           varchar(1024) DEFAULT NULL,
+    `ifDescription`  varchar(255)  DEFAULT NULL,
+    `peer`           varchar(255)  DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    KEY `idx_sid` (`sid`),
+    KEY `idx_time` (`time`)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE `ports_attributes_archive`
+(
+    `sid`   char(50)     NOT NULL,
+    `name`  varchar(64)  NOT NULL,
+    `value` varchar(255) NOT NULL,
+    PRIMARY KEY (`sid`, `name`)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE `ports_hourly`
+(
+    `time`      datetime     NOT NULL,
+    `ipaddress` varchar(255) NOT NULL,
+    `ifIndex`   int(8)       NOT NULL,
+    `hostname`  varchar(255) DEFAULT NULL,
+    `ifName`    varchar(255) DEFAULT NULL,
+    `flaps`     int(11)      NOT NULL,
+    `downs`     int(11)      NOT NULL,
+    PRIMARY KEY (`time`, `ipaddress`, `ifIndex`),
+    KEY `ipaddress_ifIndex_time` (`ipaddress`, `ifIndex`, `time`)
+) DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE `ports_daily`
+(
+    `time`      datetime     NOT NULL,
+    `ipaddress` varchar(255) NOT NULL,
+    `ifIndex`   int(8)       NOT NULL,
+    `hostname`  varchar(255) DEFAULT NULL,
+    `ifName`    varchar(255) DEFAULT NULL,
+    `flaps`     int(11)      NOT NULL,
+    `downs`     int(11)      NOT NULL,
+    PRIMARY KEY (`time`, `ipaddress`, `ifIndex`),
+    KEY `ipaddress_ifIndex_time` (`ipaddress`, `ifIndex`, `time`)
+) DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,9 @@
+ALTER TABLE `ports_archive`
+    DROP COLUMN `ifAdminStatusRaw`,
+    DROP COLUMN `ifOperStatusRaw`,
+    DROP COLUMN `trapType`;
+
+ALTER TABLE `ports`
+    DROP COLUMN `ifAdminStatusRaw`,
+    DROP COLUMN `ifOperStatusRaw`,
+    DROP COLUMN `trapType`;
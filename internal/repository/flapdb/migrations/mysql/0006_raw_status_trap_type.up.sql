@@ -0,0 +1,9 @@
+ALTER TABLE `ports`
+    ADD COLUMN `ifAdminStatusRaw` tinyint     DEFAULT NULL,
+    ADD COLUMN `ifOperStatusRaw`  tinyint     DEFAULT NULL,
+    ADD COLUMN `trapType`         varchar(16) DEFAULT NULL;
+
+ALTER TABLE `ports_archive`
+    ADD COLUMN `ifAdminStatusRaw` tinyint     DEFAULT NULL,
+    ADD COLUMN `ifOperStatusRaw`  tinyint     DEFAULT NULL,
+    ADD COLUMN `trapType`         varchar(16) DEFAULT NULL;
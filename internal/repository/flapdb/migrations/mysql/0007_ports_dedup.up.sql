@@ -0,0 +1,7 @@
+CREATE TABLE `ports_dedup`
+(
+    `dedupKey` varchar(128) NOT NULL,
+    `time`     datetime     NOT NULL,
+    PRIMARY KEY (`dedupKey`),
+    KEY `time` (`time`)
+) DEFAULT CHARSET = utf8mb4;
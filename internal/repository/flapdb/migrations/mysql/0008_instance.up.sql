@@ -0,0 +1,2 @@
+ALTER TABLE `ports` ADD COLUMN `instance` varchar(64) DEFAULT NULL;
+ALTER TABLE `ports_archive` ADD COLUMN `instance` varchar(64) DEFAULT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE `cache_ifalias` DROP INDEX `ipaddress_ifIndex`;
+ALTER TABLE `cache_ifname` DROP INDEX `ipaddress_ifIndex`;
@@ -0,0 +1,5 @@
+-- Duplicates left by concurrent writers are dropped, the latest row is kept
+DELETE c FROM `cache_ifname` c JOIN `cache_ifname` n ON n.`ipaddress` = c.`ipaddress` AND n.`ifIndex` = c.`ifIndex` AND n.`id` > c.`id`;
+DELETE c FROM `cache_ifalias` c JOIN `cache_ifalias` n ON n.`ipaddress` = c.`ipaddress` AND n.`ifIndex` = c.`ifIndex` AND n.`id` > c.`id`;
+ALTER TABLE `cache_ifname` ADD UNIQUE KEY `ipaddress_ifIndex` (`ipaddress`, `ifIndex`);
+ALTER TABLE `cache_ifalias` ADD UNIQUE KEY `ipaddress_ifIndex` (`ipaddress`, `ifIndex`);
@@ -0,0 +1,43 @@
+-- The original schema
+CREATE TABLE IF NOT EXISTS ports
+(
+    id            serial PRIMARY KEY,
+    sid           varchar(50),
+    timeTicks     bigint,
+    time          timestamp    DEFAULT NULL,
+    ipaddress     varchar(255) DEFAULT NULL,
+    hostname      varchar(255) DEFAULT NULL,
+    ifIndex       int          NOT NULL,
+    ifName        varchar(255) DEFAULT NULL,
+    ifAlias       varchar(255) DEFAULT NULL,
+    ifAdminStatus varchar(255) DEFAULT NULL,
+    ifOperStatus  varchar(255) DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sid ON ports (sid);
+CREATE INDEX IF NOT EXISTS idx_time ON ports (time);
+
+CREATE TABLE IF NOT EXISTS cache_hostname
+(
+    id        serial PRIMARY KEY,
+    time      timestamp    NOT NULL DEFAULT now(),
+    ipaddress varchar(255) DEFAULT NULL UNIQUE,
+    hostname  varchar(255) DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cache_ifname
+(
+    id        serial PRIMARY KEY,
+    time      timestamp    NOT NULL DEFAULT now(),
+    ipaddress varchar(255) NOT NULL,
+    ifIndex   int          NOT NULL,
+    ifName    varchar(50)  NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cache_ifalias
+(
+    id        serial PRIMARY KEY,
+    time      timestamp    NOT NULL DEFAULT now(),
+    ipaddress varchar(255) NOT NULL,
+    ifIndex   int          NOT NULL,
+    ifAlias   varchar(50)  DEFAULT NULL
+);
@@ -0,0 +1,15 @@
+DROP INDEX idx_parent_sid;
+DROP INDEX idx_ipaddress_ifindex_time;
+DROP TABLE IF EXISTS if_stack;
+DROP TABLE IF EXISTS lag_members;
+ALTER TABLE ports DROP COLUMN hostnameSource;
+ALTER TABLE ports DROP COLUMN lagIfIndex;
+ALTER TABLE ports DROP COLUMN lagIfName;
+ALTER TABLE ports DROP COLUMN parentIfIndex;
+ALTER TABLE ports DROP COLUMN parentSid;
+ALTER TABLE ports DROP COLUMN site;
+ALTER TABLE ports DROP COLUMN role;
+ALTER TABLE ports DROP COLUMN owner;
+ALTER TABLE ports DROP COLUMN tags;
+ALTER TABLE ports DROP COLUMN ifDescription;
+ALTER TABLE ports DROP COLUMN peer;
@@ -0,0 +1,34 @@
+ALTER TABLE ports ADD COLUMN hostnameSource varchar(16) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN lagIfIndex int DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN lagIfName varchar(255) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN parentIfIndex int DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN parentSid varchar(50) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN site varchar(255) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN role varchar(255) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN owner varchar(255) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN tags varchar(1024) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN ifDescription varchar(255) DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN peer varchar(255) DEFAULT NULL;
+
+CREATE TABLE lag_members
+(
+    id         serial PRIMARY KEY,
+    time       timestamp    NOT NULL DEFAULT now(),
+    ipaddress  varchar(255) NOT NULL,
+    ifIndex    int          NOT NULL,
+    lagIfIndex int          NOT NULL,
+    UNIQUE (ipaddress, ifIndex)
+);
+
+CREATE TABLE if_stack
+(
+    id            serial PRIMARY KEY,
+    time          timestamp    NOT NULL DEFAULT now(),
+    ipaddress     varchar(255) NOT NULL,
+    higherIfIndex int          NOT NULL,
+    lowerIfIndex  int          NOT NULL
+);
+CREATE INDEX idx_if_stack_ipaddress ON if_stack (ipaddress);
+
+CREATE INDEX idx_ipaddress_ifindex_time ON ports (ipaddress, ifIndex, time);
+CREATE INDEX idx_parent_sid ON ports (parentSid);
@@ -0,0 +1,4 @@
+DELETE FROM cache_ifname WHERE char_length(ifName) > 50;
+DELETE FROM cache_ifalias WHERE char_length(ifAlias) > 50;
+ALTER TABLE cache_ifname ALTER COLUMN ifName TYPE varchar(50);
+ALTER TABLE cache_ifalias ALTER COLUMN ifAlias TYPE varchar(50);
@@ -0,0 +1,3 @@
+-- PostgreSQL databases are UTF-8 already, only the cache columns are widened
+ALTER TABLE cache_ifname ALTER COLUMN ifName TYPE varchar(255);
+ALTER TABLE cache_ifalias ALTER COLUMN ifAlias TYPE varchar(255);
@@ -0,0 +1,7 @@
+ALTER TABLE ports ADD COLUMN ifAdminStatusRaw smallint DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN ifOperStatusRaw smallint DEFAULT NULL;
+ALTER TABLE ports ADD COLUMN trapType varchar(16) DEFAULT NULL;
+
+ALTER TABLE ports_archive ADD COLUMN ifAdminStatusRaw smallint DEFAULT NULL;
+ALTER TABLE ports_archive ADD COLUMN ifOperStatusRaw smallint DEFAULT NULL;
+ALTER TABLE ports_archive ADD COLUMN trapType varchar(16) DEFAULT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX idx_cache_ifalias_ipaddress_ifindex;
+DROP INDEX idx_cache_ifname_ipaddress_ifindex;
@@ -0,0 +1,5 @@
+-- Duplicates left by concurrent writers are dropped, the latest row is kept
+DELETE FROM cache_ifname c USING cache_ifname n WHERE n.ipaddress = c.ipaddress AND n.ifIndex = c.ifIndex AND n.id > c.id;
+DELETE FROM cache_ifalias c USING cache_ifalias n WHERE n.ipaddress = c.ipaddress AND n.ifIndex = c.ifIndex AND n.id > c.id;
+CREATE UNIQUE INDEX idx_cache_ifname_ipaddress_ifindex ON cache_ifname (ipaddress, ifIndex);
+CREATE UNIQUE INDEX idx_cache_ifalias_ipaddress_ifindex ON cache_ifalias (ipaddress, ifIndex);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS cache_ifalias;
+DROP TABLE IF EXISTS cache_ifname;
+DROP TABLE IF EXISTS cache_hostname;
+DROP TABLE IF EXISTS ports;
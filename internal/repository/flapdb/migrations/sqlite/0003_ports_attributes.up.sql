@@ -0,0 +1,9 @@
+CREATE TABLE ports_attributes
+(
+    id    integer PRIMARY KEY AUTOINCREMENT,
+    sid   varchar(50)  NOT NULL,
+    name  varchar(64)  NOT NULL,
+    value varchar(255) NOT NULL,
+    UNIQUE (sid, name)
+);
+CREATE INDEX idx_ports_attributes_name_value ON ports_attributes (name, value);
@@ -0,0 +1 @@
+-- Nothing to do: SQLite text is UTF-8 and varchar lengths are not enforced
@@ -0,0 +1,61 @@
+CREATE TABLE ports_archive
+(
+    id             int PRIMARY KEY,
+    sid            varchar(50),
+    timeTicks      bigint,
+    time           datetime      DEFAULT NULL,
+    ipaddress      varchar(255)  DEFAULT NULL,
+    hostname       varchar(255)  DEFAULT NULL,
+    hostnameSource varchar(16)   DEFAULT NULL,
+    ifIndex        int           NOT NULL,
+    ifName         varchar(255)  DEFAULT NULL,
+    ifAlias        varchar(255)  DEFAULT NULL,
+    ifAdminStatus  varchar(255)  DEFAULT NULL,
+    ifOperStatus   varchar(255)  DEFAULT NULL,
+    lagIfIndex     int           DEFAULT NULL,
+    lagIfName      varchar(255)  DEFAULT NULL,
+    parentIfIndex  int           DEFAULT NULL,
+    parentSid      varchar(50)   DEFAULT NULL,
+    site           varchar(255)  DEFAULT NULL,
+    role           varchar(255)  DEFAULT NULL,
+    owner          varchar(255)  DEFAULT NULL,
+    tags           varchar(1024) DEFAULT NULL,
+    ifDescription  varchar(255)  DEFAULT NULL,
+    peer           varchar(255)  DEFAULT NULL
+);
+CREATE INDEX idx_ports_archive_sid ON ports_archive (sid);
+CREATE INDEX idx_ports_archive_time ON ports_archive (time);
+
+CREATE TABLE ports_attributes_archive
+(
+    sid   varchar(50)  NOT NULL,
+    name  varchar(64)  NOT NULL,
+    value varchar(255) NOT NULL,
+    PRIMARY KEY (sid, name)
+);
+
+CREATE TABLE ports_hourly
+(
+    time      datetime     NOT NULL,
+    ipaddress varchar(255) NOT NULL,
+    ifIndex   int          NOT NULL,
+    hostname  varchar(255) DEFAULT NULL,
+    ifName    varchar(255) DEFAULT NULL,
+    flaps     int          NOT NULL,
+    downs     int          NOT NULL,
+    PRIMARY KEY (time, ipaddress, ifIndex)
+);
+CREATE INDEX idx_ports_hourly_ipaddress_ifindex_time ON ports_hourly (ipaddress, ifIndex, time);
+
+CREATE TABLE ports_daily
+(
+    time      datetime     NOT NULL,
+    ipaddress varchar(255) NOT NULL,
+    ifIndex   int          NOT NULL,
+    hostname  varchar(255) DEFAULT NULL,
+    ifName    varchar(255) DEFAULT NULL,
+    flaps     int          NOT NULL,
+    downs     int          NOT NULL,
+    PRIMARY KEY (time, ipaddress, ifIndex)
+);
+CREATE INDEX idx_ports_daily_ipaddress_ifindex_time ON ports_daily (ipaddress, ifIndex, time);
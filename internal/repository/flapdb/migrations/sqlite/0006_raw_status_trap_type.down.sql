@@ -0,0 +1,7 @@
+ALTER TABLE ports_archive DROP COLUMN ifAdminStatusRaw;
+ALTER TABLE ports_archive DROP COLUMN ifOperStatusRaw;
+ALTER TABLE ports_archive DROP COLUMN trapType;
+
+ALTER TABLE ports DROP COLUMN ifAdminStatusRaw;
+ALTER TABLE ports DROP COLUMN ifOperStatusRaw;
+ALTER TABLE ports DROP COLUMN trapType;
@@ -0,0 +1,6 @@
+CREATE TABLE ports_dedup
+(
+    dedupKey varchar(128) PRIMARY KEY,
+    time     datetime     NOT NULL
+);
+CREATE INDEX idx_ports_dedup_time ON ports_dedup (time);
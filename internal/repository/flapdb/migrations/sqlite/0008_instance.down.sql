@@ -0,0 +1,2 @@
+ALTER TABLE ports_archive DROP COLUMN instance;
+ALTER TABLE ports DROP COLUMN instance;
@@ -0,0 +1,5 @@
+-- Duplicates left by concurrent writers are dropped, the latest row is kept
+DELETE FROM cache_ifname WHERE id NOT IN (SELECT MAX(id) FROM cache_ifname GROUP BY ipaddress, ifIndex);
+DELETE FROM cache_ifalias WHERE id NOT IN (SELECT MAX(id) FROM cache_ifalias GROUP BY ipaddress, ifIndex);
+CREATE UNIQUE INDEX idx_cache_ifname_ipaddress_ifindex ON cache_ifname (ipaddress, ifIndex);
+CREATE UNIQUE INDEX idx_cache_ifalias_ipaddress_ifindex ON cache_ifalias (ipaddress, ifIndex);
@@ -3,7 +3,9 @@ package flapdb
 import (
 	"fmt"
 	"net"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -13,17 +15,159 @@ const (
 	// ifOperStatusDOWN  = 2
 )
 
+// Trap types of link events
+const (
+	TrapLinkUp   = "linkUp"
+	TrapLinkDown = "linkDown"
+)
+
+// Lengths of text columns created by the migrations, in characters.
+// Longer values are truncated before they are stored.
+const (
+	MaxHostnameLength       = 255
+	MaxHostnameSourceLength = 16
+	MaxIfNameLength         = 255
+	MaxIfAliasLength        = 255
+	MaxLagIfNameLength      = 255
+	MaxSiteLength           = 255
+	MaxRoleLength           = 255
+	MaxOwnerLength          = 255
+	MaxTagsLength           = 1024
+	MaxIfDescriptionLength  = 255
+	MaxPeerLength           = 255
+	MaxTrapTypeLength       = 16
+	MaxInstanceLength       = 64
+	maxAttributeNameLength  = 64
+	maxAttributeValueLength = 255
+)
+
 type Model struct {
-	Sid           string
-	IfIndex       int
+	Sid     string
+	IfIndex int
+	// IfAdminStatus and IfOperStatus are the IF-MIB enumerations as received, 0 if the trap had none.
+	// They are stored as is in ifAdminStatusRaw and ifOperStatusRaw, and as "up" or "down" in ifAdminStatus and ifOperStatus.
 	IfAdminStatus int
 	IfOperStatus  int
-	IfName        *string
-	IfAlias       *string
-	HostName      *string
-	IpAddress     net.IP
-	Time          time.Time
-	TimeTicks     uint
+	// TrapType is TrapLinkUp or TrapLinkDown
+	TrapType       string
+	IfName         *string
+	IfAlias        *string
+	HostName       *string
+	HostNameSource *string
+	IpAddress      net.IP
+	Time           time.Time
+	TimeTicks      uint
+	LagIfIndex     *int
+	LagIfName      *string
+	ParentIfIndex  *int
+	ParentSid      *string
+	Site           *string
+	Role           *string
+	Owner          *string
+	Tags           []string
+	IfDescription  *string
+	Peer           *string
+	Attributes     map[string]string
+	// Instance is the collector which received the trap
+	Instance string
+}
+
+// eventRow is a row of the ports table
+type eventRow struct {
+	Sid            string  `db:"sid"`
+	IpAddress      string  `db:"ipaddress"`
+	IfIndex        int     `db:"ifIndex"`
+	Time           string  `db:"time"`
+	TimeTicks      uint    `db:"timeTicks"`
+	HostName       *string `db:"hostname"`
+	HostNameSource *string `db:"hostnameSource"`
+	IfName         *string `db:"ifName"`
+	IfAlias        *string `db:"ifAlias"`
+	LagIfIndex     *int    `db:"lagIfIndex"`
+	LagIfName      *string `db:"lagIfName"`
+	ParentIfIndex  *int    `db:"parentIfIndex"`
+	ParentSid      *string `db:"parentSid"`
+	IfDescription  *string `db:"ifDescription"`
+	Peer           *string `db:"peer"`
+	IfAdminStatus  *int    `db:"ifAdminStatusRaw"`
+	IfOperStatus   *int    `db:"ifOperStatusRaw"`
+	TrapType       *string `db:"trapType"`
+	Instance       *string `db:"instance"`
+}
+
+func (r *eventRow) model() *Model {
+	m := &Model{
+		Sid:            r.Sid,
+		IpAddress:      net.ParseIP(r.IpAddress),
+		IfIndex:        r.IfIndex,
+		Time:           parseTime(r.Time),
+		TimeTicks:      r.TimeTicks,
+		HostName:       r.HostName,
+		HostNameSource: r.HostNameSource,
+		IfName:         r.IfName,
+		IfAlias:        r.IfAlias,
+		LagIfIndex:     r.LagIfIndex,
+		LagIfName:      r.LagIfName,
+		ParentIfIndex:  r.ParentIfIndex,
+		ParentSid:      r.ParentSid,
+		IfDescription:  r.IfDescription,
+		Peer:           r.Peer,
+	}
+
+	if r.IfAdminStatus != nil {
+		m.IfAdminStatus = *r.IfAdminStatus
+	}
+	if r.IfOperStatus != nil {
+		m.IfOperStatus = *r.IfOperStatus
+	}
+	if r.TrapType != nil {
+		m.TrapType = *r.TrapType
+	}
+	if r.Instance != nil {
+		m.Instance = *r.Instance
+	}
+	return m
+}
+
+// parseTime parses a time column scanned into a string, the zero time is returned if it can't be parsed
+func parseTime(s string) time.Time {
+
+	// MySQL returns DATETIME as is, drivers returning time.Time have it formatted as RFC 3339
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return time.Time{}
+		}
+	}
+	return t
+}
+
+// CachedValue is a value from a cache and its age
+type CachedValue struct {
+	Value string
+	Age   time.Duration
+}
+
+// cachedRow is a row of a cache table
+type cachedRow struct {
+	Value      string `db:"value"`
+	AgeSeconds int64  `db:"age"`
+}
+
+func (r *cachedRow) cachedValue() *CachedValue {
+	return &CachedValue{Value: r.Value, Age: time.Duration(r.AgeSeconds) * time.Second}
+}
+
+// LagMember binds a member interface to its LAG (port-channel) interface
+type LagMember struct {
+	IfIndex    int `db:"ifIndex"`
+	LagIfIndex int `db:"lagIfIndex"`
+}
+
+// IfStackEntry is an active ifStackTable row: HigherIfIndex runs on top of LowerIfIndex
+type IfStackEntry struct {
+	HigherIfIndex int `db:"higherIfIndex"`
+	LowerIfIndex  int `db:"lowerIfIndex"`
 }
 
 func (le *Model) String() string {
@@ -47,6 +191,106 @@ func (le *Model) String() string {
 		eventTime, hostName, ifName, le.IfIndex, ifAlias, le.ifStateText())
 }
 
+// truncate returns a copy of the string cut to max characters, so a multi-byte character is never split
+func truncate(s *string, max int) *string {
+	if s == nil {
+		return nil
+	}
+	truncated := truncateString(*s, max)
+	return &truncated
+}
+
+func truncateString(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
+
+// statusText returns ifAdminStatus and ifOperStatus as they are stored in the ports table
+func (le *Model) statusText() (ifAdminStatus, ifOperStatus string) {
+	ifAdminStatus, ifOperStatus = "down", "down"
+	if le.IfAdminStatus == ifAdminStatusUP {
+		ifAdminStatus = "up"
+	}
+
+	if le.IfOperStatus == ifOperStatusUP {
+		ifOperStatus = "up"
+	}
+	return ifAdminStatus, ifOperStatus
+}
+
+// StackCorrelated reports whether the event is a candidate to be stacked with the other one:
+// it's on the same device, stored with the same ifOperStatus and happened within the window around the other event.
+// It matches the events selectStackParentEvent and markStackChildEvents do, on any interface.
+func (le *Model) StackCorrelated(other *Model, window time.Duration) bool {
+	_, ifOperStatus := le.statusText()
+	_, otherIfOperStatus := other.statusText()
+	return le.IpAddress.Equal(other.IpAddress) && ifOperStatus == otherIfOperStatus &&
+		!le.Time.Before(other.Time.Add(-window)) && !le.Time.After(other.Time.Add(window))
+}
+
+// DedupKey identifies a trap by the device, the interface, the status and the device's sysUpTime,
+// so its retransmissions and copies sent to other collectors have the same key. Empty if the trap has no timeTicks.
+func (le *Model) DedupKey() string {
+	if le.TimeTicks == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/%d/%d/%s/%d", le.IpAddress, le.IfIndex, le.IfAdminStatus, le.IfOperStatus, le.TrapType, le.TimeTicks)
+}
+
+// rawStatus returns ifAdminStatus and ifOperStatus as they are stored in the raw status columns, NULL if unknown
+func (le *Model) rawStatus() (ifAdminStatus, ifOperStatus *int) {
+	if le.IfAdminStatus != 0 {
+		ifAdminStatus = &le.IfAdminStatus
+	}
+	if le.IfOperStatus != 0 {
+		ifOperStatus = &le.IfOperStatus
+	}
+	return ifAdminStatus, ifOperStatus
+}
+
+// trapTypeText returns the trap type as it is stored in the ports table, NULL if unknown
+func (le *Model) trapTypeText() *string {
+	if le.TrapType == "" {
+		return nil
+	}
+	return truncate(&le.TrapType, MaxTrapTypeLength)
+}
+
+// instanceText returns the instance as it is stored in the ports table, NULL if unknown
+func (le *Model) instanceText() *string {
+	if le.Instance == "" {
+		return nil
+	}
+	return truncate(&le.Instance, MaxInstanceLength)
+}
+
+// tagsText returns tags as they are stored in the ports table: comma separated, to be used with FIND_IN_SET().
+// Tags that don't fit the column are left out rather than cut.
+func (le *Model) tagsText() *string {
+
+	var tags []string
+	length := 0
+	for _, tag := range le.Tags {
+		tagLength := utf8.RuneCountInString(tag)
+		if len(tags) > 0 {
+			tagLength++
+		}
+		if length+tagLength > MaxTagsLength {
+			continue
+		}
+		tags = append(tags, tag)
+		length += tagLength
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+	text := strings.Join(tags, ",")
+	return &text
+}
+
 func (le *Model) ifStateText() string {
 	var ifState string
 
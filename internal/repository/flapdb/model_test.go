@@ -0,0 +1,61 @@
+package flapdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"Gi0/1", 255, "Gi0/1"},
+		{"uplink", 6, "uplink"},
+		{"uplink to core", 6, "uplink"},
+		// Characters are counted, not bytes
+		{"канал связи", 5, "канал"},
+		{"日本語のポート", 3, "日本語"},
+		{"", 16, ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(&tt.in, tt.max); *got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.max, *got, tt.want)
+		}
+	}
+
+	if got := truncate(nil, 10); got != nil {
+		t.Errorf("truncate(nil) = %q, want nil", *got)
+	}
+}
+
+func TestTagsText(t *testing.T) {
+
+	long := strings.Repeat("x", MaxTagsLength-4)
+
+	tests := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"no tags", nil, ""},
+		{"joined", []string{"core", "critical"}, "core,critical"},
+		{"tags not fitting are left out", []string{long, "lab", "dc", "critical"}, long + ",lab"},
+		{"a tag longer than the column", []string{long + long, "lab"}, "lab"},
+	}
+	for _, tt := range tests {
+		m := &Model{Tags: tt.tags}
+		got := m.tagsText()
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("%s: tagsText() = %q, want NULL", tt.name, *got)
+			}
+			continue
+		}
+		if got == nil || *got != tt.want {
+			t.Errorf("%s: tagsText() = %v, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package flapdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Periods the ports table is partitioned by
+const (
+	PartitionNone    = ""
+	PartitionByMonth = "month"
+	PartitionByDay   = "day"
+)
+
+const (
+	// partitionMax catches rows beyond the latest partition, new partitions are split off it
+	partitionMax = "pmax"
+	// toDaysEpoch is TO_DAYS('1970-01-01') of MySQL
+	toDaysEpoch = 719528
+)
+
+// Partition is a RANGE partition of the ports table holding events before the time, zero for partitionMax
+type Partition struct {
+	Name   string
+	Before time.Time
+}
+
+// partitionRow is a row of information_schema.PARTITIONS
+type partitionRow struct {
+	Name        string         `db:"name"`
+	Description sql.NullString `db:"description"`
+}
+
+// periodStart returns the start of the month or the day of the time
+func periodStart(t time.Time, by string) time.Time {
+	if by == PartitionByMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// nextPeriod returns the start of the month or the day following the time
+func nextPeriod(t time.Time, by string) time.Time {
+	if by == PartitionByMonth {
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.Local)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local)
+}
+
+// partitionDefinition returns the definition of a partition holding events from the time till before, named after from
+func partitionDefinition(from, before time.Time) string {
+	return fmt.Sprintf("PARTITION p%s VALUES LESS THAN (TO_DAYS('%s'))", from.Format("20060102"), before.Format("2006-01-02"))
+}
+
+// partitions returns partitions of the ports table, none if it isn't partitioned or the database isn't MySQL
+func (c *Connector) partitions(ctx context.Context) ([]Partition, error) {
+
+	if c.dialect.driver != DriverMySQL {
+		return nil, nil
+	}
+
+	var rows []partitionRow
+	if err := c.db.SelectContext(ctx, &rows, selectPortsPartitions); err != nil {
+		return nil, err
+	}
+
+	partitions := make([]Partition, 0, len(rows))
+	for _, r := range rows {
+		p := Partition{Name: r.Name}
+		if r.Description.String != "MAXVALUE" {
+			days, err := strconv.Atoi(r.Description.String)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected bound %q of partition %s", r.Description.String, r.Name)
+			}
+			p.Before = time.Date(1970, 1, 1+days-toDaysEpoch, 0, 0, 0, 0, time.Local)
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// Partitions returns partitions of the ports table, none if it isn't partitioned
+func (c *Connector) Partitions(ctx context.Context) ([]Partition, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.partitions(ctx)
+}
+
+// MaintainPartitions partitions the ports table by month or day on time, if it isn't yet,
+// and creates partitions for the current period and ahead periods after it. MySQL only.
+// Expired partitions are dropped by PurgeEvents.
+func (c *Connector) MaintainPartitions(ctx context.Context, by string, ahead int) error {
+
+	if by != PartitionByMonth && by != PartitionByDay {
+		return fmt.Errorf("unknown partition period %q", by)
+	}
+	if c.dialect.driver != DriverMySQL {
+		return fmt.Errorf("partitioning is supported by MySQL only")
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	partitions, err := c.partitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	until := nextPeriod(now, by)
+	for i := 0; i < ahead; i++ {
+		until = nextPeriod(until, by)
+	}
+
+	if len(partitions) == 0 {
+		return c.partitionPorts(ctx, by, now, until)
+	}
+
+	var bound time.Time
+	hasMax := false
+	for _, p := range partitions {
+		if p.Before.IsZero() {
+			hasMax = true
+		} else if p.Before.After(bound) {
+			bound = p.Before
+		}
+	}
+
+	if bound.IsZero() {
+		bound = periodStart(now, by)
+	}
+
+	var definitions []string
+	for bound.Before(until) {
+		next := nextPeriod(bound, by)
+		definitions = append(definitions, partitionDefinition(bound, next))
+		bound = next
+	}
+	if len(definitions) == 0 {
+		return nil
+	}
+
+	created := len(definitions)
+	query := fmt.Sprintf(addPortsPartitions, strings.Join(definitions, ", "))
+	if hasMax {
+		definitions = append(definitions, "PARTITION "+partitionMax+" VALUES LESS THAN MAXVALUE")
+		query = fmt.Sprintf(splitPortsMaxPartition, partitionMax, strings.Join(definitions, ", "))
+	}
+	if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	log.Printf("%d partitions of ports created, up to %s", created, until.Format("2006-01-02"))
+	return nil
+}
+
+// partitionPorts converts the ports table to a partitioned one. Events before the current period are put
+// into a single partition. time becomes a part of the primary key, as MySQL requires, so events without time,
+// which the original schema allowed, are moved to ports_archive first.
+func (c *Connector) partitionPorts(ctx context.Context, by string, now, until time.Time) error {
+
+	archived, err := c.archiveUntimedEvents(ctx)
+	if err != nil {
+		return fmt.Errorf("ports is not partitioned, unable to archive events without time: %w", err)
+	}
+	if archived > 0 {
+		log.Printf("%d events without time moved from ports to ports_archive, they can't be partitioned", archived)
+	}
+
+	log.Printf("Partitioning ports by %s, it may take a while", by)
+
+	start := periodStart(now, by)
+	var definitions []string
+
+	var first sql.NullString
+	if err := c.db.GetContext(ctx, &first, selectFirstEvent); err != nil {
+		return err
+	}
+	if first.Valid {
+		if from := periodStart(parseTime(first.String), by); from.Before(start) {
+			definitions = append(definitions, partitionDefinition(from, start))
+		}
+	}
+
+	for bound := start; bound.Before(until); {
+		next := nextPeriod(bound, by)
+		definitions = append(definitions, partitionDefinition(bound, next))
+		bound = next
+	}
+	definitions = append(definitions, "PARTITION "+partitionMax+" VALUES LESS THAN MAXVALUE")
+
+	if _, err := c.db.ExecContext(ctx, partitionPortsPrimaryKey); err != nil {
+		return err
+	}
+	if _, err := c.db.ExecContext(ctx, fmt.Sprintf(partitionPortsByRange, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+
+	log.Printf("ports partitioned by %s into %d partitions", by, len(definitions))
+	return nil
+}
+
+// archiveUntimedEvents moves events with NULL time and their attributes to the archive tables
+// and returns the number of events moved
+func (c *Connector) archiveUntimedEvents(ctx context.Context) (int64, error) {
+
+	var count int64
+	if err := c.db.GetContext(ctx, &count, countUntimedEvents); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	// Rows archived by an interrupted conversion are archived again
+	for _, q := range []string{unarchiveUntimedEvents, unarchiveUntimedAttributes, archiveUntimedEvents,
+		archiveUntimedAttributes, deleteUntimedAttributes} {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, deleteUntimedEvents)
+	if err != nil {
+		return 0, err
+	}
+	if count, err = result.RowsAffected(); err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+// dropPartitions drops partitions holding events before the time only and returns the number of events dropped
+func (c *Connector) dropPartitions(ctx context.Context, partitions []Partition, before time.Time) (int64, error) {
+
+	var names []string
+	var bound time.Time
+	for _, p := range partitions {
+		if !p.Before.IsZero() && !p.Before.After(before) {
+			names = append(names, p.Name)
+			if p.Before.After(bound) {
+				bound = p.Before
+			}
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+
+	var dropped int64
+	if err := c.db.GetContext(ctx, &dropped, c.query(countEventsBefore), bound.Format("2006-01-02 15:04:05")); err != nil {
+		return 0, err
+	}
+
+	if _, err := c.db.ExecContext(ctx, fmt.Sprintf(dropPortsPartitions, strings.Join(names, ", "))); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Partitions of ports dropped: %s", strings.Join(names, ", "))
+	return dropped, nil
+}
+
+const (
+	selectPortsPartitions = `SELECT PARTITION_NAME AS name, PARTITION_DESCRIPTION AS description FROM information_schema.PARTITIONS
+									WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'ports' AND PARTITION_NAME IS NOT NULL
+									ORDER BY PARTITION_ORDINAL_POSITION;`
+	partitionPortsPrimaryKey = "ALTER TABLE `ports` MODIFY `time` datetime NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (`id`, `time`);"
+	partitionPortsByRange    = "ALTER TABLE `ports` PARTITION BY RANGE (TO_DAYS(`time`)) (%s);"
+	addPortsPartitions       = "ALTER TABLE `ports` ADD PARTITION (%s);"
+	splitPortsMaxPartition   = "ALTER TABLE `ports` REORGANIZE PARTITION %s INTO (%s);"
+	dropPortsPartitions      = "ALTER TABLE `ports` DROP PARTITION %s;"
+	countEventsBefore        = `SELECT COUNT(*) FROM ports WHERE time < ?;`
+
+	countUntimedEvents         = `SELECT COUNT(*) FROM ports WHERE time IS NULL;`
+	unarchiveUntimedEvents     = `DELETE FROM ports_archive WHERE id IN (SELECT id FROM ports WHERE time IS NULL);`
+	unarchiveUntimedAttributes = `DELETE FROM ports_attributes_archive
+									WHERE sid IN (SELECT a.sid FROM ports_attributes a JOIN ports p ON p.sid = a.sid WHERE p.time IS NULL);`
+	archiveUntimedEvents     = `INSERT INTO ports_archive (` + portsColumns + `) SELECT ` + portsColumns + ` FROM ports WHERE time IS NULL;`
+	archiveUntimedAttributes = `INSERT INTO ports_attributes_archive (sid, name, value)
+									SELECT a.sid, a.name, a.value FROM ports_attributes a JOIN ports p ON p.sid = a.sid WHERE p.time IS NULL;`
+	deleteUntimedAttributes = `DELETE FROM ports_attributes WHERE sid IN (SELECT sid FROM ports WHERE time IS NULL);`
+	deleteUntimedEvents     = `DELETE FROM ports WHERE time IS NULL;`
+)
@@ -0,0 +1,63 @@
+package flapdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriods(t *testing.T) {
+
+	tests := []struct {
+		at    time.Time
+		by    string
+		start time.Time
+		next  time.Time
+	}{
+		{time.Date(2026, 10, 18, 12, 30, 0, 0, time.Local), PartitionByDay,
+			time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local)},
+		{time.Date(2026, 10, 31, 23, 59, 59, 0, time.Local), PartitionByDay,
+			time.Date(2026, 10, 31, 0, 0, 0, 0, time.Local), time.Date(2026, 11, 1, 0, 0, 0, 0, time.Local)},
+		{time.Date(2026, 10, 18, 12, 30, 0, 0, time.Local), PartitionByMonth,
+			time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 11, 1, 0, 0, 0, 0, time.Local)},
+		{time.Date(2026, 12, 31, 23, 0, 0, 0, time.Local), PartitionByMonth,
+			time.Date(2026, 12, 1, 0, 0, 0, 0, time.Local), time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local)},
+		{time.Date(2028, 2, 29, 0, 0, 0, 0, time.Local), PartitionByDay,
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.Local), time.Date(2028, 3, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		if got := periodStart(tt.at, tt.by); !got.Equal(tt.start) {
+			t.Errorf("periodStart(%s, %s) = %s, want %s", tt.at, tt.by, got, tt.start)
+		}
+		if got := nextPeriod(tt.at, tt.by); !got.Equal(tt.next) {
+			t.Errorf("nextPeriod(%s, %s) = %s, want %s", tt.at, tt.by, got, tt.next)
+		}
+	}
+}
+
+func TestPartitionDefinition(t *testing.T) {
+
+	tests := []struct {
+		from, before time.Time
+		want         string
+	}{
+		{time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 11, 1, 0, 0, 0, 0, time.Local),
+			"PARTITION p20261001 VALUES LESS THAN (TO_DAYS('2026-11-01'))"},
+		{time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local), time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local),
+			"PARTITION p20261231 VALUES LESS THAN (TO_DAYS('2027-01-01'))"},
+	}
+	for _, tt := range tests {
+		if got := partitionDefinition(tt.from, tt.before); got != tt.want {
+			t.Errorf("partitionDefinition(%s, %s) = %q, want %q", tt.from, tt.before, got, tt.want)
+		}
+	}
+}
+
+func TestToDaysEpoch(t *testing.T) {
+
+	// TO_DAYS('2026-10-18') of MySQL, the bound partitions reads back from information_schema
+	days := 740272
+	want := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	if got := time.Date(1970, 1, 1+days-toDaysEpoch, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("TO_DAYS %d = %s, want %s", days, got, want)
+	}
+}
@@ -2,48 +2,127 @@ package flapdb
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
 	"log"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
+// multiRowInsertSize is the maximum number of rows in a multi-row INSERT statement
+const multiRowInsertSize = 500
+
+// cacheInterfaceKey is the unique key of cache_ifname and cache_ifalias
+var cacheInterfaceKey = []string{"ipaddress", "ifIndex"}
+
 // Connector is an object to connect the database
 type Connector struct {
-	db                   *sqlx.DB
+	db      *sqlx.DB
+	dialect *dialect
+	// locks holds connections of advisory locks apart from the pool of db, nil for SQLite
+	locks                *sql.DB
 	mx                   sync.Mutex
 	cacheIfNameMinutes   int
 	cacheIfAliasMinutes  int
 	cacheHostnameMinutes int
+	dedupWindow          time.Duration
+	duplicates           int64
 }
 
 type Config struct {
-	CacheIfNameMinutes           int
-	CacheIfAliasMinutes          int
-	CacheHostnameMinutes         int
+	// Cached values are kept for that long, stale ones included
+	CacheIfNameMinutes   int
+	CacheIfAliasMinutes  int
+	CacheHostnameMinutes int
+	// Driver is DriverMySQL (default), DriverPostgres or DriverSQLite. DBName is a file path for SQLite.
+	Driver                       string
 	Host, DBName, User, Password string
+	// Port is used unless Host has one, 0 means the driver default
+	Port int
+
+	TLS TLSConfig
+
+	// Timeouts, 0 means the driver default. PostgreSQL has the connect timeout only.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	// Connection pool, 0 means the database/sql default
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// Params are extra DSN parameters passed to the driver as is, e.g. parseTime or charset of MySQL
+	Params map[string]string
+
+	// DedupWindow skips events whose dedup key is in the ports_dedup table, stored by this or another instance
+	// within the window. 0 disables it.
+	DedupWindow time.Duration
 }
 
 // MakeDB returns an SQL Connector object to make queries
 func MakeDB(cfg *Config) (*Connector, error) {
 
-	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s)/%s", cfg.User, cfg.Password, cfg.Host, cfg.DBName)
-	db, err := sqlx.Open("mysql", dataSourceName)
+	d, err := newDialect(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn, err := d.dataSourceName(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlx.Open(d.driver, dsn)
 	if err != nil {
 		return nil, err
 	}
+	d.setMapper(db)
+
+	// Zero keeps the database/sql default: SetMaxIdleConns(0) would disable idle connections
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
 
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
+	d.setUp(db)
+
+	// A lock keeps its connection while held, which would starve a small pool or deadlock a pool of one.
+	// sql.Open doesn't connect, so the pool is free until a lock is taken.
+	var locks *sql.DB
+	if d.driver != DriverSQLite {
+		if locks, err = sql.Open(d.driver, dsn); err != nil {
+			db.Close()
+			return nil, err
+		}
+		// A released lock closes its connection, so the session and its locks end with it
+		locks.SetMaxIdleConns(0)
+	}
+
 	return &Connector{
 		db:                   db,
+		dialect:              d,
+		locks:                locks,
 		cacheIfNameMinutes:   cfg.CacheIfNameMinutes,
 		cacheIfAliasMinutes:  cfg.CacheIfAliasMinutes,
 		cacheHostnameMinutes: cfg.CacheHostnameMinutes,
+		dedupWindow:          cfg.DedupWindow,
 	}, nil
 }
 
@@ -58,20 +137,20 @@ func (c *Connector) CleanUp(ctx context.Context) error {
 		return err
 	}
 	defer func() {
-		if err := tx.Rollback(); err != nil {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
 			log.Println(err)
 		}
 	}()
 
 	log.Printf("Cleanup DB started")
 
-	if _, err := tx.ExecContext(ctx, cleanUpHostnameSQL, c.cacheHostnameMinutes); err != nil {
+	if _, err := tx.ExecContext(ctx, c.query(cleanUpHostnameSQL), c.cacheHostnameMinutes); err != nil {
 		return err
 	}
-	if _, err := tx.ExecContext(ctx, cleanUpIfNameSQL, c.cacheIfNameMinutes); err != nil {
+	if _, err := tx.ExecContext(ctx, c.query(cleanUpIfNameSQL), c.cacheIfNameMinutes); err != nil {
 		return err
 	}
-	if _, err := tx.ExecContext(ctx, cleanUpIfAliasSQL, c.cacheIfAliasMinutes); err != nil {
+	if _, err := tx.ExecContext(ctx, c.query(cleanUpIfAliasSQL), c.cacheIfAliasMinutes); err != nil {
 		return err
 	}
 
@@ -83,139 +162,415 @@ func (c *Connector) CleanUp(ctx context.Context) error {
 }
 
 func (c *Connector) SaveLinkEvent(le *Model) error {
+	return c.SaveLinkEvents(context.Background(), []*Model{le})
+}
+
+// SaveLinkEvents inserts events and their attributes with multi-row statements in one transaction
+func (c *Connector) SaveLinkEvents(ctx context.Context, events []*Model) error {
 
-	if le.TimeTicks == 0 {
-		log.Println("SNMP Trap has no timeTicks", le)
+	for _, le := range events {
+		if le.TimeTicks == 0 {
+			log.Println("SNMP Trap has no timeTicks", le)
+		}
 	}
 
-	ifAdminStatus, ifOperStatus := "down", "down"
-	if le.IfAdminStatus == ifAdminStatusUP {
-		ifAdminStatus = "up"
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
 
-	if le.IfOperStatus == ifOperStatusUP {
-		ifOperStatus = "up"
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	if c.dedupWindow > 0 {
+		if events, err = c.claimEvents(ctx, tx, events); err != nil {
+			return err
+		}
 	}
 
-	sql := `INSERT INTO ports 
-			(ipaddress, hostname, ifIndex, ifName, ifAlias, ifAdminStatus, ifOperStatus, time, sid, timeTicks)
-			VALUES 
-			(:ipaddress, :hostname, :ifIndex, :ifName, :ifAlias, :ifAdminStatus, :ifOperStatus, :time, :sid, :timeTicks)`
+	for start := 0; start < len(events); start += multiRowInsertSize {
+		end := start + multiRowInsertSize
+		if end > len(events) {
+			end = len(events)
+		}
 
-	args := map[string]interface{}{
-		"ipaddress":     le.IpAddress.String(),
-		"hostname":      le.HostName,
-		"ifIndex":       le.IfIndex,
-		"ifName":        le.IfName,
-		"ifAlias":       le.IfAlias,
-		"ifAdminStatus": ifAdminStatus,
-		"ifOperStatus":  ifOperStatus,
-		"time":          le.Time.Format("2006-01-02 15:04:05"),
-		"sid":           le.Sid,
-		"timeTicks":     le.TimeTicks}
+		rows := make([]string, 0, end-start)
+		var args []interface{}
+		for _, le := range events[start:end] {
+			ifAdminStatus, ifOperStatus := le.statusText()
+			ifAdminStatusRaw, ifOperStatusRaw := le.rawStatus()
+			rows = append(rows, insertLinkEventRow)
+			args = append(args, le.IpAddress.String(), truncate(le.HostName, MaxHostnameLength),
+				truncate(le.HostNameSource, MaxHostnameSourceLength),
+				le.IfIndex, truncate(le.IfName, MaxIfNameLength), truncate(le.IfAlias, MaxIfAliasLength),
+				ifAdminStatus, ifOperStatus, le.Time.Format("2006-01-02 15:04:05"), le.Sid, le.TimeTicks,
+				le.LagIfIndex, truncate(le.LagIfName, MaxLagIfNameLength), le.ParentIfIndex, le.ParentSid,
+				truncate(le.Site, MaxSiteLength), truncate(le.Role, MaxRoleLength), truncate(le.Owner, MaxOwnerLength),
+				le.tagsText(), truncate(le.IfDescription, MaxIfDescriptionLength), truncate(le.Peer, MaxPeerLength),
+				ifAdminStatusRaw, ifOperStatusRaw, le.trapTypeText(), le.instanceText())
+		}
 
-	c.mx.Lock()
-	defer c.mx.Unlock()
+		if _, err := tx.ExecContext(ctx, c.query(insertLinkEvents+strings.Join(rows, ", ")), args...); err != nil {
+			log.Println("unable to exec SQL query", err)
+			return err
+		}
+	}
 
-	if _, err := c.db.NamedExec(sql, args); err != nil {
-		log.Println(le.Sid, "unable to exec SQL query", err)
-		return err
+	var rows []string
+	var args []interface{}
+	for _, le := range events {
+		for name, value := range le.Attributes {
+			rows = append(rows, "(?, ?, ?)")
+			args = append(args, le.Sid, truncateString(name, maxAttributeNameLength), truncateString(value, maxAttributeValueLength))
+		}
+	}
+	if len(rows) > 0 {
+		if _, err := tx.ExecContext(ctx, c.query(insertPortAttributes+strings.Join(rows, ", ")), args...); err != nil {
+			return err
+		}
 	}
 
 	// logVerbose(fmt.Sprintln(le.sid, "link event saved", le.String()))
-	return nil
+	return tx.Commit()
 }
 
+// claimEvents stores dedup keys of the events and returns the events whose keys weren't stored within the window.
+// A key stored before the window is deleted first, so the window doesn't depend on how often keys are purged.
+// A key being stored by a concurrent transaction waits for it, so an event is claimed by one instance only.
+func (c *Connector) claimEvents(ctx context.Context, tx *sql.Tx, events []*Model) ([]*Model, error) {
+
+	claimed := make([]*Model, 0, len(events))
+	for _, le := range events {
+		key := le.DedupKey()
+		if key == "" {
+			claimed = append(claimed, le)
+			continue
+		}
+
+		expired := le.Time.Add(-c.dedupWindow).Format("2006-01-02 15:04:05")
+		if _, err := tx.ExecContext(ctx, c.query(deleteExpiredDedupKey), key, expired); err != nil {
+			return nil, err
+		}
+
+		result, err := tx.ExecContext(ctx, c.query(c.dialect.ignoreDuplicates(insertDedupKey)), key, le.Time.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return nil, err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		if inserted == 0 {
+			atomic.AddInt64(&c.duplicates, 1)
+			log.Println(le.Sid, "duplicate link event skipped:", key)
+			continue
+		}
+		claimed = append(claimed, le)
+	}
+	return claimed, nil
+}
+
+// Duplicates returns the number of duplicate events skipped since start
+func (c *Connector) Duplicates() int64 {
+	return atomic.LoadInt64(&c.duplicates)
+}
+
+// PurgeDedupKeys deletes dedup keys stored before the time
+func (c *Connector) PurgeDedupKeys(ctx context.Context, before time.Time) (int64, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	result, err := c.db.ExecContext(ctx, c.query(purgeDedupKeys), before.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateLinkEvent stores the enriched fields of an event. A nil parent keeps the stored one,
+// which MarkStackChildEvents of the parent's event may have set meanwhile.
 func (c *Connector) UpdateLinkEvent(le *Model) error {
 
-	sql := `UPDATE ports SET  hostname = :hostname, ifName = :ifName, ifAlias = :ifAlias WHERE sid = :sid;`
+	query := `UPDATE ports SET  hostname = :hostname, hostnameSource = :hostnameSource, ifName = :ifName, ifAlias = :ifAlias,
+			lagIfIndex = :lagIfIndex, lagIfName = :lagIfName,
+			parentIfIndex = COALESCE(:parentIfIndex, parentIfIndex), parentSid = COALESCE(:parentSid, parentSid),
+			ifDescription = :ifDescription, peer = :peer WHERE sid = :sid;`
 
 	args := map[string]interface{}{
-		"hostname": le.HostName,
-		"ifAlias":  le.IfAlias,
-		"ifName":   le.IfName,
-		"sid":      le.Sid}
+		"hostname":       truncate(le.HostName, MaxHostnameLength),
+		"hostnameSource": truncate(le.HostNameSource, MaxHostnameSourceLength),
+		"ifAlias":        truncate(le.IfAlias, MaxIfAliasLength),
+		"ifName":         truncate(le.IfName, MaxIfNameLength),
+		"lagIfIndex":     le.LagIfIndex,
+		"lagIfName":      truncate(le.LagIfName, MaxLagIfNameLength),
+		"parentIfIndex":  le.ParentIfIndex,
+		"parentSid":      le.ParentSid,
+		"ifDescription":  truncate(le.IfDescription, MaxIfDescriptionLength),
+		"peer":           truncate(le.Peer, MaxPeerLength),
+		"sid":            le.Sid}
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
-	if _, err := c.db.NamedExec(sql, args); err != nil {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	if _, err := tx.NamedExec(query, args); err != nil {
 		log.Println(le.Sid, "unable to exec SQL query", err)
 		return err
 	}
 
+	// Attributes parsed from ifAlias are replaced together with the ifAlias
+	if _, err := tx.Exec(c.query(deletePortAttributes), le.Sid); err != nil {
+		return err
+	}
+	for name, value := range le.Attributes {
+		if _, err := tx.Exec(c.query(insertPortAttribute), le.Sid, truncateString(name, maxAttributeNameLength),
+			truncateString(value, maxAttributeValueLength), le.Sid); err != nil {
+			return err
+		}
+	}
+
 	// logVerbose(fmt.Sprintln(le.sid, "link event updated", le.String()))
-	return nil
+	return tx.Commit()
 }
 
-func (c *Connector) GetCachedIfName(le *Model) (*string, error) {
+func (c *Connector) GetCachedIfName(le *Model) (*CachedValue, error) {
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
-	sql := "SELECT ifName FROM cache_ifname	WHERE time > now() - INTERVAL ? MINUTE AND ipaddress = ? AND ifIndex = ?;"
-
-	cachedIfName := ""
-	if err := c.db.Get(&cachedIfName, sql, c.cacheIfNameMinutes, le.IpAddress.String(), le.IfIndex); err != nil {
+	cachedIfName := cachedRow{}
+	if err := c.db.Get(&cachedIfName, c.query(selectIfNameWhereTime), c.cacheIfNameMinutes, le.IpAddress.String(), le.IfIndex); err != nil {
 		// logVerbose(fmt.Sprintln(le.sid, "no cached ifName"))
 		return nil, err
 	}
 
-	return &cachedIfName, nil
+	return cachedIfName.cachedValue(), nil
 }
 
+// PutCachedIfName stores the ifName of an interface with a single upsert on the unique (ipaddress, ifIndex)
 func (c *Connector) PutCachedIfName(ctx context.Context, m *Model) error {
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
+	query := c.dialect.upsert(setCacheIfName, cacheInterfaceKey, []string{"ifName", "time"})
+	if _, err := c.db.ExecContext(ctx, c.query(query), m.IpAddress.String(), m.IfIndex, truncate(m.IfName, MaxIfNameLength)); err != nil {
+		log.Println(m.Sid, err, m.String())
+		return err
+	}
+
+	// logVerbose(fmt.Sprintf("%s put values ('%s', '%d', '%d') to cache_ifname", le.sid, *le.ifName, le.ifIndex, le.hostName))
+
+	return nil
+}
+
+func (c *Connector) GetCachedIfAlias(le *Model) (*CachedValue, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	cachedIfAlias := cachedRow{}
+	if err := c.db.Get(&cachedIfAlias, c.query(selectIfAliasWhereTime), c.cacheIfAliasMinutes, le.IpAddress.String(), le.IfIndex); err != nil {
+		// logVerbose(fmt.Sprintln(le.sid, "no cached ifAlias"))
+		return nil, err
+	}
+
+	return cachedIfAlias.cachedValue(), nil
+}
+
+// PutCachedIfAlias stores the ifAlias of an interface with a single upsert on the unique (ipaddress, ifIndex)
+func (c *Connector) PutCachedIfAlias(ctx context.Context, m *Model) error {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	query := c.dialect.upsert(setCacheIfAlias, cacheInterfaceKey, []string{"ifAlias", "time"})
+	if _, err := c.db.ExecContext(ctx, c.query(query), m.IpAddress.String(), m.IfIndex, truncate(m.IfAlias, MaxIfAliasLength)); err != nil {
+		log.Println(m.Sid, err)
+		return err
+	}
+
+	// logVerbose(fmt.Sprintf("%s put values ('%s', '%d', '%s') to cache_ifalias", le.sid, *le.ifAlias, le.ifIndex, le.ipAddress))
+
+	return nil
+}
+
+func (c *Connector) GetCachedHostname(le *Model) (*CachedValue, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var cachedHostname cachedRow
+	if err := c.db.Get(&cachedHostname, c.query(selecthostnameWhereTime), c.cacheHostnameMinutes, le.IpAddress.String()); err != nil {
+		// logVerbose(fmt.Sprintln(le.sid, "no cached hostname"))
+		return nil, err
+	}
+
+	return cachedHostname.cachedValue(), nil
+}
+
+// PutCachedHostname stores the hostname of a device. It is a single upsert on the unique ipaddress,
+// so instances sharing the database may put the same device concurrently.
+func (c *Connector) PutCachedHostname(ctx context.Context, m *Model) error {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	query := c.dialect.upsert(setCacheHostName, []string{"ipaddress"}, []string{"hostname", "time"})
+	if _, err := c.db.ExecContext(ctx, c.query(query), m.IpAddress.String(), truncate(m.HostName, MaxHostnameLength)); err != nil {
+		log.Println(m.Sid, err)
+		return err
+	}
+
+	// logVerbose(fmt.Sprintf("%s put values ('%s', '%s') to cache_hostname", le.sid, *le.hostName, le.ipAddress))
+
+	return nil
+}
+
+// PutCachedIfNames replaces cached ifNames of a device, values are keyed by ifIndex
+func (c *Connector) PutCachedIfNames(ctx context.Context, ip net.IP, ifNames map[int]string) error {
+	return c.replaceDeviceCache(ctx, deleteIfNameWhereIPaddr, insertCacheIfNames, "ifName", ip, ifNames, MaxIfNameLength)
+}
+
+// PutCachedIfAliases replaces cached ifAliases of a device, values are keyed by ifIndex
+func (c *Connector) PutCachedIfAliases(ctx context.Context, ip net.IP, ifAliases map[int]string) error {
+	return c.replaceDeviceCache(ctx, deleteIfAliasWhereIPaddr, insertCacheIfAliases, "ifAlias", ip, ifAliases, MaxIfAliasLength)
+}
+
+// InvalidateDevice deletes cached ifNames and ifAliases of a device
+func (c *Connector) InvalidateDevice(ctx context.Context, ip net.IP) error {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
 	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
-		if err := tx.Rollback(); err != nil {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
 			log.Println(err)
 		}
 	}()
 
-	if _, err := tx.ExecContext(ctx, deleteIfnameIfindex, m.IpAddress.String(), m.IfIndex); err != nil {
-		log.Println(m.Sid, err)
+	if _, err := tx.ExecContext(ctx, c.query(deleteIfNameWhereIPaddr), ip.String()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, c.query(deleteIfAliasWhereIPaddr), ip.String()); err != nil {
 		return err
 	}
 
-	if _, err := c.db.ExecContext(ctx, setCacheIfName, m.IpAddress.String(), m.IfIndex, m.IfName); err != nil {
-		log.Println(m.Sid, err, m.String())
+	return tx.Commit()
+}
+
+// replaceDeviceCache deletes cached rows of a device and upserts the values into the column with multi-row statements,
+// so rows put by a concurrent writer meanwhile are updated. Values are truncated to maxLength characters.
+func (c *Connector) replaceDeviceCache(ctx context.Context, deleteSQL, insertSQL, column string, ip net.IP, values map[int]string, maxLength int) error {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, c.query(deleteSQL), ip.String()); err != nil {
 		return err
 	}
 
-	// logVerbose(fmt.Sprintf("%s put values ('%s', '%d', '%d') to cache_ifname", le.sid, *le.ifName, le.ifIndex, le.hostName))
+	rows := make([]string, 0, multiRowInsertSize)
+	args := make([]interface{}, 0, multiRowInsertSize*3)
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		query := c.dialect.upsert(insertSQL+strings.Join(rows, ", "), cacheInterfaceKey, []string{column, "time"})
+		if _, err := tx.ExecContext(ctx, c.query(query), args...); err != nil {
+			return err
+		}
+		rows, args = rows[:0], args[:0]
+		return nil
+	}
 
-	return nil
+	for ifIndex, value := range values {
+		rows = append(rows, "(?, ?, ?, now())")
+		args = append(args, ip.String(), ifIndex, truncateString(value, maxLength))
+		if len(rows) == multiRowInsertSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (c *Connector) GetCachedIfAlias(le *Model) (*string, error) {
+// GetIncompleteEvents returns events with hostname, ifName or ifAlias missing in the order of time and sid,
+// starting after the event of the time and sid. An empty sid starts at the time.
+func (c *Connector) GetIncompleteEvents(ctx context.Context, since time.Time, afterSid string, limit int) ([]*Model, error) {
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
-	sql := "SELECT ifAlias FROM cache_ifalias WHERE time > now() - INTERVAL ? MINUTE AND ipaddress = ? AND ifIndex = ?;"
+	sinceText := since.Format("2006-01-02 15:04:05")
 
-	cachedIfAlias := ""
-	if err := c.db.Get(&cachedIfAlias, sql, c.cacheIfAliasMinutes, le.IpAddress.String(), le.IfIndex); err != nil {
-		// logVerbose(fmt.Sprintln(le.sid, "no cached ifAlias"))
+	var rows []eventRow
+	if err := c.db.SelectContext(ctx, &rows, c.query(selectIncompleteEvents), sinceText, sinceText, afterSid, limit); err != nil {
 		return nil, err
 	}
 
-	return &cachedIfAlias, nil
+	events := make([]*Model, 0, len(rows))
+	for i := range rows {
+		events = append(events, rows[i].model())
+	}
+	return events, nil
 }
 
-func (c *Connector) PutCachedIfAlias(ctx context.Context, m *Model) error {
+// GetLagMember returns the LAG an interface is a member of
+func (c *Connector) GetLagMember(le *Model) (*LagMember, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	member := LagMember{}
+	if err := c.db.Get(&member, c.query(selectLagMember), le.IpAddress.String(), le.IfIndex); err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// PutLagMembers replaces the LAG membership of a device
+func (c *Connector) PutLagMembers(ctx context.Context, ip net.IP, members []LagMember) error {
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
@@ -226,45 +581,40 @@ func (c *Connector) PutCachedIfAlias(ctx context.Context, m *Model) error {
 	}
 
 	defer func() {
-		if err := tx.Rollback(); err != nil {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
 			log.Println(err)
 		}
 	}()
 
-	if _, err := tx.ExecContext(ctx, deleteIfAliasIfindex, m.IpAddress.String(), m.IfIndex); err != nil {
-		log.Println(m.Sid, err)
-		return err
-	}
-
-	if _, err := tx.ExecContext(ctx, setCacheIfAlias, m.IpAddress.String(), m.IfIndex, m.IfAlias); err != nil {
-		log.Println(m.Sid, err)
+	if _, err := tx.ExecContext(ctx, c.query(deleteLagMembers), ip.String()); err != nil {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	for _, member := range members {
+		if _, err := tx.ExecContext(ctx, c.query(insertLagMember), ip.String(), member.IfIndex, member.LagIfIndex); err != nil {
+			return err
+		}
 	}
 
-	// logVerbose(fmt.Sprintf("%s put values ('%s', '%d', '%s') to cache_ifalias", le.sid, *le.ifAlias, le.ifIndex, le.ipAddress))
-
-	return nil
+	return tx.Commit()
 }
 
-func (c *Connector) GetCachedHostname(le *Model) (*string, error) {
+// GetIfStack returns ifStackTable of a device
+func (c *Connector) GetIfStack(m *Model) ([]IfStackEntry, error) {
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
-	var cachedHostname string
-	if err := c.db.Get(&cachedHostname, selecthostnameWhereTime, c.cacheHostnameMinutes, le.IpAddress.String()); err != nil {
-		// logVerbose(fmt.Sprintln(le.sid, "no cached hostname"))
+	var stack []IfStackEntry
+	if err := c.db.Select(&stack, c.query(selectIfStack), m.IpAddress.String()); err != nil {
 		return nil, err
 	}
 
-	return &cachedHostname, nil
+	return stack, nil
 }
 
-func (c *Connector) PutCachedHostname(ctx context.Context, m *Model) error {
+// PutIfStack replaces ifStackTable of a device
+func (c *Connector) PutIfStack(ctx context.Context, ip net.IP, stack []IfStackEntry) error {
 
 	c.mx.Lock()
 	defer c.mx.Unlock()
@@ -275,42 +625,116 @@ func (c *Connector) PutCachedHostname(ctx context.Context, m *Model) error {
 	}
 
 	defer func() {
-		if err := tx.Rollback(); err != nil {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
 			log.Println(err)
 		}
 	}()
 
-	if _, err := tx.ExecContext(ctx, deleteHostNameWhereIPaddr, m.IpAddress.String()); err != nil {
-		log.Println(m.Sid, err)
+	if _, err := tx.ExecContext(ctx, c.query(deleteIfStack), ip.String()); err != nil {
 		return err
 	}
 
-	if _, err := tx.ExecContext(ctx, setCacheHostName, m.IpAddress.String(), m.HostName); err != nil {
-		return err
+	for _, entry := range stack {
+		if _, err := tx.ExecContext(ctx, c.query(insertIfStack), ip.String(), entry.HigherIfIndex, entry.LowerIfIndex); err != nil {
+			return err
+		}
 	}
 
-	if err := tx.Commit(); err != nil {
+	return tx.Commit()
+}
+
+// GetStackParentEvent returns sid of the event with the same status on the parent interface
+// that happened within the window around the child event
+func (c *Connector) GetStackParentEvent(parent *Model, window time.Duration) (*string, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	_, ifOperStatus := parent.statusText()
+	from, to := parent.Time.Add(-window), parent.Time.Add(window)
+
+	var parentSid string
+	if err := c.db.Get(&parentSid, c.query(selectStackParentEvent), parent.IpAddress.String(), parent.IfIndex, ifOperStatus,
+		from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")); err != nil {
+		return nil, err
+	}
+
+	return &parentSid, nil
+}
+
+// MarkStackChildEvents links events with the same status on child interfaces,
+// that happened within the window around the parent event, to the parent event
+func (c *Connector) MarkStackChildEvents(ctx context.Context, parent *Model, children []int, window time.Duration) error {
+
+	_, ifOperStatus := parent.statusText()
+	from, to := parent.Time.Add(-window), parent.Time.Add(window)
+
+	query, args, err := sqlx.In(markStackChildEvents, parent.IfIndex, parent.Sid, parent.IpAddress.String(), children,
+		ifOperStatus, from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05"))
+	if err != nil {
 		return err
 	}
 
-	// logVerbose(fmt.Sprintf("%s put values ('%s', '%s') to cache_hostname", le.sid, *le.hostName, le.ipAddress))
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if _, err := c.db.ExecContext(ctx, c.query(query), args...); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// Ping checks the database is reachable
+func (c *Connector) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
 func (c *Connector) Close() {
 	c.db.Close()
+	if c.locks != nil {
+		c.locks.Close()
+	}
 }
 
 const (
-	cleanUpHostnameSQL        = `DELETE FROM cache_hostname WHERE time < now() - INTERVAL ? MINUTE;`
-	cleanUpIfNameSQL          = `DELETE FROM cache_ifname WHERE time < now() - INTERVAL ? MINUTE;`
-	cleanUpIfAliasSQL         = `DELETE FROM cache_ifalias WHERE time < now() - INTERVAL ? MINUTE;`
-	deleteIfnameIfindex       = `DELETE FROM cache_ifname WHERE ipaddress = ? and ifIndex = ?;`
-	setCacheIfName            = `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName) VALUES (?, ?, ?);`
-	deleteIfAliasIfindex      = `DELETE FROM cache_ifalias WHERE ipaddress = ? and ifindex = ?;`
-	setCacheIfAlias           = `INSERT INTO cache_ifalias (ipaddress, ifIndex, ifAlias) VALUES (?, ?, ?);`
-	selecthostnameWhereTime   = "SELECT hostname FROM cache_hostname WHERE time > now() - INTERVAL ? MINUTE AND ipaddress = ?;"
-	deleteHostNameWhereIPaddr = `DELETE FROM cache_hostname WHERE ipaddress = ?;`
-	setCacheHostName          = `INSERT INTO cache_hostname (ipaddress, hostname) VALUES (?, ?);`
+	cleanUpHostnameSQL       = `DELETE FROM cache_hostname WHERE time < now() - INTERVAL ? MINUTE;`
+	cleanUpIfNameSQL         = `DELETE FROM cache_ifname WHERE time < now() - INTERVAL ? MINUTE;`
+	cleanUpIfAliasSQL        = `DELETE FROM cache_ifalias WHERE time < now() - INTERVAL ? MINUTE;`
+	selectIfNameWhereTime    = "SELECT ifName AS value, TIMESTAMPDIFF(SECOND, time, now()) AS age FROM cache_ifname WHERE time > now() - INTERVAL ? MINUTE AND ipaddress = ? AND ifIndex = ?;"
+	setCacheIfName           = `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES (?, ?, ?, now());`
+	selectIfAliasWhereTime   = "SELECT ifAlias AS value, TIMESTAMPDIFF(SECOND, time, now()) AS age FROM cache_ifalias WHERE time > now() - INTERVAL ? MINUTE AND ipaddress = ? AND ifIndex = ?;"
+	setCacheIfAlias          = `INSERT INTO cache_ifalias (ipaddress, ifIndex, ifAlias, time) VALUES (?, ?, ?, now());`
+	selecthostnameWhereTime  = "SELECT hostname AS value, TIMESTAMPDIFF(SECOND, time, now()) AS age FROM cache_hostname WHERE time > now() - INTERVAL ? MINUTE AND ipaddress = ?;"
+	setCacheHostName         = `INSERT INTO cache_hostname (ipaddress, hostname, time) VALUES (?, ?, now());`
+	deleteIfNameWhereIPaddr  = `DELETE FROM cache_ifname WHERE ipaddress = ?;`
+	insertCacheIfNames       = `INSERT INTO cache_ifname (ipaddress, ifIndex, ifName, time) VALUES `
+	deleteIfAliasWhereIPaddr = `DELETE FROM cache_ifalias WHERE ipaddress = ?;`
+	insertCacheIfAliases     = `INSERT INTO cache_ifalias (ipaddress, ifIndex, ifAlias, time) VALUES `
+	selectIncompleteEvents   = `SELECT sid, ipaddress, ifIndex, time, timeTicks, hostname, hostnameSource, ifName, ifAlias,
+									lagIfIndex, lagIfName, parentIfIndex, parentSid, ifDescription, peer,
+									ifAdminStatusRaw, ifOperStatusRaw, trapType, instance
+									FROM ports WHERE (time > ? OR (time = ? AND sid > ?)) AND (hostname IS NULL OR ifName IS NULL OR ifAlias IS NULL)
+									ORDER BY time, sid LIMIT ?;`
+	deletePortAttributes = `DELETE FROM ports_attributes WHERE sid = ?;`
+	insertPortAttribute  = `INSERT INTO ports_attributes (sid, name, value) SELECT ?, ?, ? FROM ports WHERE sid = ?;`
+	insertPortAttributes = `INSERT INTO ports_attributes (sid, name, value) VALUES `
+	insertLinkEvents     = `INSERT INTO ports (ipaddress, hostname, hostnameSource, ifIndex, ifName, ifAlias, ifAdminStatus, ifOperStatus,
+									time, sid, timeTicks, lagIfIndex, lagIfName, parentIfIndex, parentSid,
+									site, role, owner, tags, ifDescription, peer, ifAdminStatusRaw, ifOperStatusRaw, trapType, instance) VALUES `
+	insertDedupKey         = `INSERT INTO ports_dedup (dedupKey, time) VALUES (?, ?);`
+	purgeDedupKeys         = `DELETE FROM ports_dedup WHERE time < ?;`
+	deleteExpiredDedupKey  = `DELETE FROM ports_dedup WHERE dedupKey = ? AND time < ?;`
+	insertLinkEventRow     = `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	selectLagMember        = `SELECT ifIndex, lagIfIndex FROM lag_members WHERE ipaddress = ? AND ifIndex = ?;`
+	deleteLagMembers       = `DELETE FROM lag_members WHERE ipaddress = ?;`
+	insertLagMember        = `INSERT INTO lag_members (ipaddress, ifIndex, lagIfIndex) VALUES (?, ?, ?);`
+	selectIfStack          = `SELECT higherIfIndex, lowerIfIndex FROM if_stack WHERE ipaddress = ?;`
+	deleteIfStack          = `DELETE FROM if_stack WHERE ipaddress = ?;`
+	insertIfStack          = `INSERT INTO if_stack (ipaddress, higherIfIndex, lowerIfIndex) VALUES (?, ?, ?);`
+	selectStackParentEvent = `SELECT sid FROM ports WHERE ipaddress = ? AND ifIndex = ? AND ifOperStatus = ?
+									AND time BETWEEN ? AND ? ORDER BY time DESC LIMIT 1;`
+	markStackChildEvents = `UPDATE ports SET parentIfIndex = ?, parentSid = ?
+									WHERE ipaddress = ? AND ifIndex IN (?) AND ifOperStatus = ?
+									AND time BETWEEN ? AND ? AND parentSid IS NULL;`
 )
@@ -0,0 +1,55 @@
+package flapdb
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClaimEventsWindow(t *testing.T) {
+
+	c, err := MakeDB(&Config{Driver: DriverSQLite, DBName: filepath.Join(t.TempDir(), "flap.db"), DedupWindow: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	if _, err := c.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	flap := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	trap := func(sid string, at time.Time) *Model {
+		return &Model{Sid: sid, IpAddress: net.ParseIP("192.0.2.1"), IfIndex: 1, IfAdminStatus: 1, IfOperStatus: 2,
+			TrapType: "linkDown", TimeTicks: 1000, Time: at}
+	}
+
+	tests := []struct {
+		name           string
+		event          *Model
+		wantDuplicates int64
+	}{
+		{"first copy", trap("a", flap), 0},
+		{"copy within the window", trap("b", flap.Add(time.Second*30)), 1},
+		{"copy after the window, the key not purged yet", trap("c", flap.Add(time.Minute*2)), 1},
+		{"copy within the window of the replaced key", trap("d", flap.Add(time.Minute*2+time.Second*30)), 2},
+	}
+	for _, tt := range tests {
+		if err := c.SaveLinkEvents(ctx, []*Model{tt.event}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if c.Duplicates() != tt.wantDuplicates {
+			t.Errorf("%s: %d duplicates, want %d", tt.name, c.Duplicates(), tt.wantDuplicates)
+		}
+	}
+
+	var stored []string
+	if err := c.db.Select(&stored, "SELECT sid FROM ports ORDER BY time;"); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0] != "a" || stored[1] != "c" {
+		t.Errorf("stored %v, want [a c]", stored)
+	}
+}
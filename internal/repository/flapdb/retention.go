@@ -0,0 +1,378 @@
+package flapdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// rollUpStep is the longest range of hours rolled up at once after the latest rolled up hour
+const rollUpStep = 24 * time.Hour
+
+// Rollup is a number of link events of an interface within an hour or a day
+type Rollup struct {
+	Time      time.Time
+	IpAddress string
+	IfIndex   int
+	HostName  *string
+	IfName    *string
+	Flaps     int
+	Downs     int
+}
+
+type rollupKey struct {
+	time      time.Time
+	ipAddress string
+	ifIndex   int
+}
+
+// rollupEventRow is a row of the ports table as it is rolled up
+type rollupEventRow struct {
+	Time         string  `db:"time"`
+	IpAddress    string  `db:"ipaddress"`
+	IfIndex      int     `db:"ifIndex"`
+	HostName     *string `db:"hostname"`
+	IfName       *string `db:"ifName"`
+	IfOperStatus *string `db:"ifOperStatus"`
+}
+
+// rollupRow is a row of the ports_hourly and ports_daily tables
+type rollupRow struct {
+	Time      string  `db:"time"`
+	IpAddress string  `db:"ipaddress"`
+	IfIndex   int     `db:"ifIndex"`
+	HostName  *string `db:"hostname"`
+	IfName    *string `db:"ifName"`
+	Flaps     int     `db:"flaps"`
+	Downs     int     `db:"downs"`
+}
+
+// truncateHour returns the start of the hour of a time read from the database.
+// Times are stored as local wall clock, whatever location a driver has returned them in.
+func truncateHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// add counts an event or a smaller rollup into the rollups
+func add(rollups map[rollupKey]*Rollup, order *[]rollupKey, key rollupKey, hostName, ifName *string, flaps, downs int) {
+	r, ok := rollups[key]
+	if !ok {
+		r = &Rollup{Time: key.time, IpAddress: key.ipAddress, IfIndex: key.ifIndex}
+		rollups[key] = r
+		*order = append(*order, key)
+	}
+	r.Flaps += flaps
+	r.Downs += downs
+
+	// Rows are read in time order, the latest known names win
+	if hostName != nil {
+		r.HostName = hostName
+	}
+	if ifName != nil {
+		r.IfName = ifName
+	}
+}
+
+// RollUpEvents counts link events per interface into hourly and daily rollups, for complete hours before until.
+// The latest rolled up hour is counted again, so events stored late are not missed.
+// It returns the start of that hour: events before it are rolled up for good. The zero time means nothing is rolled up yet.
+func (c *Connector) RollUpEvents(ctx context.Context, until time.Time) (time.Time, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var last sql.NullString
+	if err := c.db.GetContext(ctx, &last, c.query(selectLastHourlyRollup)); err != nil {
+		return time.Time{}, err
+	}
+
+	var start time.Time
+	if last.Valid {
+		start = truncateHour(parseTime(last.String))
+	} else {
+		var first sql.NullString
+		if err := c.db.GetContext(ctx, &first, c.query(selectFirstEvent)); err != nil {
+			return time.Time{}, err
+		}
+		if !first.Valid {
+			return time.Time{}, nil
+		}
+		start = truncateHour(parseTime(first.String))
+	}
+
+	// Hours without events are skipped
+	var next sql.NullString
+	if err := c.db.GetContext(ctx, &next, c.query(selectFirstEventSince), start.Add(time.Hour).Format("2006-01-02 15:04:05")); err != nil {
+		return time.Time{}, err
+	}
+	end := start.Add(time.Hour)
+	if next.Valid {
+		end = truncateHour(parseTime(next.String)).Add(rollUpStep)
+	}
+	if until = truncateHour(until); end.After(until) {
+		end = until
+	}
+	if !end.After(start) {
+		return start, nil
+	}
+
+	var events []rollupEventRow
+	if err := c.db.SelectContext(ctx, &events, c.query(selectRollupEvents),
+		start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05")); err != nil {
+		return time.Time{}, err
+	}
+
+	hourly := map[rollupKey]*Rollup{}
+	var hours []rollupKey
+	latest := start
+	for _, e := range events {
+		hour := truncateHour(parseTime(e.Time))
+		downs := 0
+		if e.IfOperStatus != nil && *e.IfOperStatus == "down" {
+			downs = 1
+		}
+		add(hourly, &hours, rollupKey{hour, e.IpAddress, e.IfIndex}, e.HostName, e.IfName, 1, downs)
+		if hour.After(latest) {
+			latest = hour
+		}
+	}
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	if err := c.replaceRollups(ctx, tx, deleteHourlyRollups, insertHourlyRollups, start, end, hourly, hours); err != nil {
+		return time.Time{}, err
+	}
+
+	// Days touched by the hours are counted again from the hourly rollups
+	dayStart, dayEnd := truncateDay(start), truncateDay(end.Add(-time.Hour)).AddDate(0, 0, 1)
+	var hourRows []rollupRow
+	if err := tx.SelectContext(ctx, &hourRows, c.query(selectHourlyRollups),
+		dayStart.Format("2006-01-02 15:04:05"), dayEnd.Format("2006-01-02 15:04:05")); err != nil {
+		return time.Time{}, err
+	}
+
+	daily := map[rollupKey]*Rollup{}
+	var days []rollupKey
+	for _, h := range hourRows {
+		day := truncateDay(parseTime(h.Time))
+		add(daily, &days, rollupKey{day, h.IpAddress, h.IfIndex}, h.HostName, h.IfName, h.Flaps, h.Downs)
+	}
+
+	if err := c.replaceRollups(ctx, tx, deleteDailyRollups, insertDailyRollups, dayStart, dayEnd, daily, days); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	return latest, nil
+}
+
+// replaceRollups replaces rollups within [from, to) in the same order they were counted
+func (c *Connector) replaceRollups(ctx context.Context, tx *sqlx.Tx, deleteSQL, insertSQL string, from, to time.Time,
+	rollups map[rollupKey]*Rollup, order []rollupKey) error {
+
+	if _, err := tx.ExecContext(ctx, c.query(deleteSQL), from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(order); start += multiRowInsertSize {
+		end := start + multiRowInsertSize
+		if end > len(order) {
+			end = len(order)
+		}
+
+		rows := make([]string, 0, end-start)
+		var args []interface{}
+		for _, key := range order[start:end] {
+			r := rollups[key]
+			rows = append(rows, "(?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, r.Time.Format("2006-01-02 15:04:05"), r.IpAddress, r.IfIndex,
+				truncate(r.HostName, MaxHostnameLength), truncate(r.IfName, MaxIfNameLength), r.Flaps, r.Downs)
+		}
+
+		if _, err := tx.ExecContext(ctx, c.query(insertSQL+strings.Join(rows, ", ")), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeHourlyRollups deletes hourly rollups older than the time, daily rollups are kept
+func (c *Connector) PurgeHourlyRollups(ctx context.Context, before time.Time) (int64, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	result, err := c.db.ExecContext(ctx, c.query(purgeHourlyRollups), before.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// archiveRecord is a line of an archive file
+type archiveRecord struct {
+	Table string                 `json:"table"`
+	Row   map[string]interface{} `json:"row"`
+}
+
+// ArchiveEvents writes events older than the time and their attributes to w as JSON lines,
+// one {"table": ..., "row": {column: value}} object per row. It returns the number of events written.
+func (c *Connector) ArchiveEvents(ctx context.Context, before time.Time, w io.Writer) (int, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	encoder := json.NewEncoder(w)
+	count := 0
+	for _, q := range []struct{ table, query string }{
+		{"ports", selectArchiveEvents},
+		{"ports_attributes", selectArchiveAttributes},
+	} {
+		rows, err := c.db.QueryxContext(ctx, c.query(q.query), before.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return count, err
+		}
+
+		for rows.Next() {
+			row := map[string]interface{}{}
+			if err := rows.MapScan(row); err != nil {
+				rows.Close()
+				return count, err
+			}
+			for column, value := range row {
+				if b, ok := value.([]byte); ok {
+					row[column] = string(b)
+				}
+			}
+			if err := encoder.Encode(archiveRecord{Table: q.table, Row: row}); err != nil {
+				rows.Close()
+				return count, err
+			}
+			if q.table == "ports" {
+				count++
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return count, err
+		}
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// PurgeEvents deletes events older than the time and their attributes.
+// With toTable the rows are moved to the ports_archive and ports_attributes_archive tables.
+// Partitions of a partitioned ports table holding older events only are dropped instead of deleting their rows.
+func (c *Connector) PurgeEvents(ctx context.Context, before time.Time, toTable bool) (int64, error) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	partitions, err := c.partitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Println(err)
+		}
+	}()
+
+	// Rows archived by an interrupted purge are archived again
+	queries := []string{deleteArchivedAttributes}
+	if toTable {
+		queries = []string{unarchiveEvents, unarchiveAttributes, archiveEvents, archiveAttributes, deleteArchivedAttributes}
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, c.query(q), before.Format("2006-01-02 15:04:05")); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(partitions) == 0 {
+		purged, err := c.purgeEvents(ctx, tx, before)
+		if err != nil {
+			return 0, err
+		}
+		return purged, tx.Commit()
+	}
+
+	// ALTER TABLE commits implicitly, so archived rows are committed first
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	dropped, err := c.dropPartitions(ctx, partitions, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := c.purgeEvents(ctx, c.db, before)
+	return dropped + purged, err
+}
+
+// purgeEvents deletes events older than the time
+func (c *Connector) purgeEvents(ctx context.Context, db sqlx.ExecerContext, before time.Time) (int64, error) {
+
+	result, err := db.ExecContext(ctx, c.query(purgeEvents), before.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// portsColumns are the columns of the ports and ports_archive tables
+const portsColumns = `id, sid, timeTicks, time, ipaddress, hostname, hostnameSource, ifIndex, ifName, ifAlias,
+									ifAdminStatus, ifOperStatus, lagIfIndex, lagIfName, parentIfIndex, parentSid,
+									site, role, owner, tags, ifDescription, peer, ifAdminStatusRaw, ifOperStatusRaw, trapType, instance`
+
+const (
+	selectLastHourlyRollup = `SELECT MAX(time) FROM ports_hourly;`
+	selectFirstEvent       = `SELECT MIN(time) FROM ports;`
+	selectFirstEventSince  = `SELECT MIN(time) FROM ports WHERE time >= ?;`
+	selectRollupEvents     = `SELECT time, ipaddress, ifIndex, hostname, ifName, ifOperStatus FROM ports
+									WHERE time >= ? AND time < ? AND ipaddress IS NOT NULL ORDER BY time;`
+	deleteHourlyRollups = `DELETE FROM ports_hourly WHERE time >= ? AND time < ?;`
+	insertHourlyRollups = `INSERT INTO ports_hourly (time, ipaddress, ifIndex, hostname, ifName, flaps, downs) VALUES `
+	selectHourlyRollups = `SELECT time, ipaddress, ifIndex, hostname, ifName, flaps, downs FROM ports_hourly
+									WHERE time >= ? AND time < ? ORDER BY time;`
+	deleteDailyRollups      = `DELETE FROM ports_daily WHERE time >= ? AND time < ?;`
+	insertDailyRollups      = `INSERT INTO ports_daily (time, ipaddress, ifIndex, hostname, ifName, flaps, downs) VALUES `
+	purgeHourlyRollups      = `DELETE FROM ports_hourly WHERE time < ?;`
+	selectArchiveEvents     = `SELECT * FROM ports WHERE time < ? ORDER BY time;`
+	selectArchiveAttributes = `SELECT a.sid, a.name, a.value FROM ports_attributes a JOIN ports p ON p.sid = a.sid WHERE p.time < ?;`
+	unarchiveEvents         = `DELETE FROM ports_archive WHERE id IN (SELECT id FROM ports WHERE time < ?);`
+	unarchiveAttributes     = `DELETE FROM ports_attributes_archive
+									WHERE sid IN (SELECT a.sid FROM ports_attributes a JOIN ports p ON p.sid = a.sid WHERE p.time < ?);`
+	archiveEvents     = `INSERT INTO ports_archive (` + portsColumns + `) SELECT ` + portsColumns + ` FROM ports WHERE time < ?;`
+	archiveAttributes = `INSERT INTO ports_attributes_archive (sid, name, value)
+									SELECT a.sid, a.name, a.value FROM ports_attributes a JOIN ports p ON p.sid = a.sid WHERE p.time < ?;`
+	deleteArchivedAttributes = `DELETE FROM ports_attributes WHERE sid IN (SELECT sid FROM ports WHERE time < ?);`
+	purgeEvents              = `DELETE FROM ports WHERE time < ?;`
+)
@@ -2,32 +2,77 @@ package repository
 
 import (
 	"context"
+	"io"
+	"net"
 	"snmpflapd/internal/repository/flapdb"
+	"time"
 )
 
 var _ Connector = &flapdb.Connector{}
+var _ EventRetention = &flapdb.Connector{}
+var _ PartitionManager = &flapdb.Connector{}
 
 // Connector is an object to connect the database
 type Connector interface {
-	// CleanUp deletes old cached values from DB
-	CleanUp(ctx context.Context) error
-
 	// Close connection to db
 	Close()
 
 	SaveLinkEvent(*flapdb.Model) error
 
+	// SaveLinkEvents inserts a batch of events
+	SaveLinkEvents(context.Context, []*flapdb.Model) error
+
 	UpdateLinkEvent(*flapdb.Model) error
 
-	GetCachedIfName(*flapdb.Model) (*string, error)
+	// GetIncompleteEvents returns events with hostname, ifName or ifAlias missing, ordered by time and sid,
+	// after the event of the time and sid
+	GetIncompleteEvents(ctx context.Context, since time.Time, afterSid string, limit int) ([]*flapdb.Model, error)
+
+	// GetLagMember returns the LAG bundle an interface belongs to
+	GetLagMember(*flapdb.Model) (*flapdb.LagMember, error)
+
+	// PutLagMembers replaces LAG membership of a device
+	PutLagMembers(context.Context, net.IP, []flapdb.LagMember) error
 
-	PutCachedIfName(context.Context, *flapdb.Model) error
+	// GetIfStack returns ifStackTable of a device
+	GetIfStack(*flapdb.Model) ([]flapdb.IfStackEntry, error)
 
-	GetCachedIfAlias(*flapdb.Model) (*string, error)
+	// PutIfStack replaces ifStackTable of a device
+	PutIfStack(context.Context, net.IP, []flapdb.IfStackEntry) error
+
+	// GetStackParentEvent returns sid of the parent interface event matching a child event
+	GetStackParentEvent(*flapdb.Model, time.Duration) (*string, error)
+
+	// MarkStackChildEvents links child interface events to the parent interface event
+	MarkStackChildEvents(context.Context, *flapdb.Model, []int, time.Duration) error
+}
 
-	PutCachedIfAlias(context.Context, *flapdb.Model) error
+// EventRetention rolls up, archives and purges stored link events
+type EventRetention interface {
+	// RollUpEvents counts events into hourly and daily rollups and returns the time events are rolled up before
+	RollUpEvents(ctx context.Context, until time.Time) (time.Time, error)
 
-	GetCachedHostname(*flapdb.Model) (*string, error)
+	// PurgeHourlyRollups deletes hourly rollups older than the time
+	PurgeHourlyRollups(ctx context.Context, before time.Time) (int64, error)
+
+	// ArchiveEvents writes events older than the time to the writer
+	ArchiveEvents(ctx context.Context, before time.Time, w io.Writer) (int, error)
+
+	// PurgeEvents deletes events older than the time, moving them to archive tables if toTable is set
+	PurgeEvents(ctx context.Context, before time.Time, toTable bool) (int64, error)
+
+	// PurgeDedupKeys deletes dedup keys stored before the time
+	PurgeDedupKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Locker takes advisory locks shared by the instances using the database
+type Locker interface {
+	// TryLock takes the named lock without waiting, nil is returned if another instance holds it
+	TryLock(ctx context.Context, name string) (*flapdb.Lock, error)
+}
 
-	PutCachedHostname(context.Context, *flapdb.Model) error
+// PartitionManager keeps the events table partitioned by time
+type PartitionManager interface {
+	// MaintainPartitions creates partitions of the current period and the ahead periods after it
+	MaintainPartitions(ctx context.Context, by string, ahead int) error
 }
@@ -0,0 +1,137 @@
+package memcache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lru is a size-bounded string cache with per-entry expiry.
+// The least recently used entry is evicted when the cache is full.
+type lru struct {
+	mx   sync.Mutex
+	size int
+	ttl  time.Duration
+	// hold bounds the time an entry is kept since it is put, 0 means until it is older than ttl
+	hold  time.Duration
+	items map[string]*list.Element
+	order *list.List
+	stats Stats
+}
+
+type lruItem struct {
+	key      string
+	value    string
+	storedAt time.Time
+	putAt    time.Time
+}
+
+// Stats are counters of a cache
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+func newLRU(size int, ttl, hold time.Duration) *lru {
+	return &lru{
+		size:  size,
+		ttl:   ttl,
+		hold:  hold,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+// get returns a value and its age
+func (c *lru) get(key string) (string, time.Duration, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return "", 0, false
+	}
+
+	item := element.Value.(*lruItem)
+	age := time.Since(item.storedAt)
+	if c.expired(item) {
+		c.removeElement(element)
+		c.stats.Misses++
+		return "", 0, false
+	}
+
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return item.value, age, true
+}
+
+// put stores a value of the age
+func (c *lru) put(key, value string, age time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := time.Now()
+	storedAt := now.Add(-age)
+
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*lruItem)
+		item.value, item.storedAt, item.putAt = value, storedAt, now
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{key: key, value: value, storedAt: storedAt, putAt: now})
+
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// purge removes expired entries
+func (c *lru) purge() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	for element := c.order.Back(); element != nil; {
+		prev := element.Prev()
+		if c.expired(element.Value.(*lruItem)) {
+			c.removeElement(element)
+		}
+		element = prev
+	}
+}
+
+// deletePrefix removes entries with keys starting with the prefix
+func (c *lru) deletePrefix(prefix string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	for key, element := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(element)
+		}
+	}
+}
+
+func (c *lru) expired(item *lruItem) bool {
+	return time.Since(item.storedAt) > c.ttl || (c.hold > 0 && time.Since(item.putAt) > c.hold)
+}
+
+func (c *lru) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*lruItem).key)
+}
+
+func (c *lru) getStats() Stats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
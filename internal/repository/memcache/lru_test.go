@@ -0,0 +1,116 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+
+	type op struct {
+		put   bool
+		key   string
+		value string
+		age   time.Duration
+	}
+
+	tests := []struct {
+		name    string
+		ops     []op
+		present map[string]string
+		absent  []string
+		stats   Stats
+	}{
+		{"least recently used is evicted", []op{
+			{put: true, key: "a", value: "1"},
+			{put: true, key: "b", value: "2"},
+			{key: "a"},
+			{put: true, key: "c", value: "3"},
+		}, map[string]string{"a": "1", "c": "3"}, []string{"b"}, Stats{Hits: 1, Evictions: 1, Entries: 2}},
+		{"put refreshes an entry", []op{
+			{put: true, key: "a", value: "1"},
+			{put: true, key: "b", value: "2"},
+			{put: true, key: "a", value: "10"},
+			{put: true, key: "c", value: "3"},
+		}, map[string]string{"a": "10", "c": "3"}, []string{"b"}, Stats{Evictions: 1, Entries: 2}},
+		{"expired entry is a miss", []op{
+			{put: true, key: "a", value: "1", age: time.Hour * 2},
+			{key: "a"},
+			{put: true, key: "b", value: "2", age: time.Minute * 59},
+		}, map[string]string{"b": "2"}, []string{"a"}, Stats{Misses: 1, Entries: 1}},
+	}
+	for _, tt := range tests {
+		c := newLRU(2, time.Hour, 0)
+		for _, o := range tt.ops {
+			if o.put {
+				c.put(o.key, o.value, o.age)
+			} else {
+				c.get(o.key)
+			}
+		}
+
+		if stats := c.getStats(); stats != tt.stats {
+			t.Errorf("%s: stats %+v, want %+v", tt.name, stats, tt.stats)
+		}
+		for key, want := range tt.present {
+			if value, _, ok := c.get(key); !ok || value != want {
+				t.Errorf("%s: %s = %q, %v, want %q", tt.name, key, value, ok, want)
+			}
+		}
+		for _, key := range tt.absent {
+			if value, _, ok := c.get(key); ok {
+				t.Errorf("%s: %s = %q, want a miss", tt.name, key, value)
+			}
+		}
+	}
+}
+
+func TestLRUAge(t *testing.T) {
+
+	c := newLRU(10, time.Hour, 0)
+	c.put("a", "1", time.Minute*30)
+
+	_, age, ok := c.get("a")
+	if !ok || age < time.Minute*30 || age > time.Minute*31 {
+		t.Errorf("age %s, %v, want 30m", age, ok)
+	}
+}
+
+func TestLRUPurge(t *testing.T) {
+
+	c := newLRU(10, time.Hour, 0)
+	c.put("fresh", "1", 0)
+	c.put("expired", "2", time.Hour*2)
+	c.put("192.0.2.1/1", "Gi0/1", 0)
+	c.put("192.0.2.1/2", "Gi0/2", 0)
+	c.put("192.0.2.10/1", "eth1", 0)
+
+	c.purge()
+	c.deletePrefix("192.0.2.1/")
+
+	want := map[string]bool{"fresh": true, "192.0.2.10/1": true}
+	if len(c.items) != len(want) || c.order.Len() != len(want) {
+		t.Errorf("%d entries, %d in order, want %d", len(c.items), c.order.Len(), len(want))
+	}
+	for key := range c.items {
+		if !want[key] {
+			t.Errorf("%s is kept", key)
+		}
+	}
+}
+
+func TestLRUHold(t *testing.T) {
+
+	c := newLRU(10, time.Hour, time.Minute)
+	c.put("recent", "1", time.Minute*50)
+	c.put("held", "2", 0)
+	c.items["held"].Value.(*lruItem).putAt = time.Now().Add(-time.Minute * 2)
+
+	// An old value of the backend is held for a minute since it is put, a recent put for no longer
+	if _, _, ok := c.get("recent"); !ok {
+		t.Error("value put a moment ago is a miss")
+	}
+	if value, _, ok := c.get("held"); ok {
+		t.Errorf("value held longer than a minute = %q, want a miss", value)
+	}
+}
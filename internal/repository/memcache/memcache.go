@@ -0,0 +1,208 @@
+// Package memcache is an in-process cache of hostnames, ifNames and ifAliases with TTL and LRU eviction.
+// It works standalone or as a tier in front of another repository.Cache: writes go through
+// to the backend, which is queried on a miss only.
+package memcache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"time"
+)
+
+// Config holds the cache settings
+type Config struct {
+	// Size is the maximum number of entries of each cache
+	Size   int
+	Policy repository.CachePolicy
+	// Hold bounds the time a value is kept in memory, 0 means until it expires. A backend shared
+	// by instances needs a short one: a device invalidated by another instance is cleared in the backend only.
+	Hold time.Duration
+}
+
+// Cache is a repository.Cache kept in memory
+type Cache struct {
+	// backend is nil for a standalone cache
+	backend repository.Cache
+
+	hostnames *lru
+	ifNames   *lru
+	ifAliases *lru
+}
+
+var _ repository.Cache = &Cache{}
+
+// New returns a Cache in front of the backend. The backend may be nil.
+func New(backend repository.Cache, cfg Config) *Cache {
+	return &Cache{
+		backend:   backend,
+		hostnames: newLRU(cfg.Size, cfg.Policy.Retention(cfg.Policy.HostnameTTL), cfg.Hold),
+		ifNames:   newLRU(cfg.Size, cfg.Policy.Retention(cfg.Policy.IfNameTTL), cfg.Hold),
+		ifAliases: newLRU(cfg.Size, cfg.Policy.Retention(cfg.Policy.IfAliasTTL), cfg.Hold),
+	}
+}
+
+// CleanUp deletes expired values from memory and the backend
+func (c *Cache) CleanUp(ctx context.Context) error {
+
+	c.hostnames.purge()
+	c.ifNames.purge()
+	c.ifAliases.purge()
+
+	if c.backend == nil {
+		return nil
+	}
+	return c.backend.CleanUp(ctx)
+}
+
+// Close closes the backend
+func (c *Cache) Close() {
+	if c.backend != nil {
+		c.backend.Close()
+	}
+}
+
+func (c *Cache) GetCachedIfName(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get(c.ifNames, interfaceKey(m.IpAddress, m.IfIndex), m, func(b repository.Cache) func(*flapdb.Model) (*flapdb.CachedValue, error) {
+		return b.GetCachedIfName
+	})
+}
+
+func (c *Cache) PutCachedIfName(ctx context.Context, m *flapdb.Model) error {
+	if c.backend != nil {
+		if err := c.backend.PutCachedIfName(ctx, m); err != nil {
+			return err
+		}
+	}
+	if m.IfName != nil {
+		c.ifNames.put(interfaceKey(m.IpAddress, m.IfIndex), *m.IfName, 0)
+	}
+	return nil
+}
+
+func (c *Cache) GetCachedIfAlias(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get(c.ifAliases, interfaceKey(m.IpAddress, m.IfIndex), m, func(b repository.Cache) func(*flapdb.Model) (*flapdb.CachedValue, error) {
+		return b.GetCachedIfAlias
+	})
+}
+
+func (c *Cache) PutCachedIfAlias(ctx context.Context, m *flapdb.Model) error {
+	if c.backend != nil {
+		if err := c.backend.PutCachedIfAlias(ctx, m); err != nil {
+			return err
+		}
+	}
+	if m.IfAlias != nil {
+		c.ifAliases.put(interfaceKey(m.IpAddress, m.IfIndex), *m.IfAlias, 0)
+	}
+	return nil
+}
+
+func (c *Cache) GetCachedHostname(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get(c.hostnames, m.IpAddress.String(), m, func(b repository.Cache) func(*flapdb.Model) (*flapdb.CachedValue, error) {
+		return b.GetCachedHostname
+	})
+}
+
+func (c *Cache) PutCachedHostname(ctx context.Context, m *flapdb.Model) error {
+	if c.backend != nil {
+		if err := c.backend.PutCachedHostname(ctx, m); err != nil {
+			return err
+		}
+	}
+	if m.HostName != nil {
+		c.hostnames.put(m.IpAddress.String(), *m.HostName, 0)
+	}
+	return nil
+}
+
+func (c *Cache) PutCachedIfNames(ctx context.Context, ip net.IP, ifNames map[int]string) error {
+	if c.backend != nil {
+		if err := c.backend.PutCachedIfNames(ctx, ip, ifNames); err != nil {
+			return err
+		}
+	}
+	// Interfaces gone from the device are gone from the cache, like in the other backends
+	c.ifNames.deletePrefix(ip.String() + "/")
+	for ifIndex, ifName := range ifNames {
+		c.ifNames.put(interfaceKey(ip, ifIndex), ifName, 0)
+	}
+	return nil
+}
+
+func (c *Cache) PutCachedIfAliases(ctx context.Context, ip net.IP, ifAliases map[int]string) error {
+	if c.backend != nil {
+		if err := c.backend.PutCachedIfAliases(ctx, ip, ifAliases); err != nil {
+			return err
+		}
+	}
+	// Interfaces gone from the device are gone from the cache, like in the other backends
+	c.ifAliases.deletePrefix(ip.String() + "/")
+	for ifIndex, ifAlias := range ifAliases {
+		c.ifAliases.put(interfaceKey(ip, ifIndex), ifAlias, 0)
+	}
+	return nil
+}
+
+// InvalidateDevice deletes cached ifNames and ifAliases of a device from memory and the backend
+func (c *Cache) InvalidateDevice(ctx context.Context, ip net.IP) error {
+
+	c.ifNames.deletePrefix(ip.String() + "/")
+	c.ifAliases.deletePrefix(ip.String() + "/")
+
+	if c.backend == nil {
+		return nil
+	}
+	return c.backend.InvalidateDevice(ctx, ip)
+}
+
+// Stats returns counters of every cache
+func (c *Cache) Stats() map[string]Stats {
+	return map[string]Stats{
+		"hostname": c.hostnames.getStats(),
+		"ifName":   c.ifNames.getStats(),
+		"ifAlias":  c.ifAliases.getStats(),
+	}
+}
+
+// RunStatsLog periodically logs cache statistics
+func (c *Cache) RunStatsLog(ctx context.Context, period time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(period):
+			for name, stats := range c.Stats() {
+				log.Printf("Memory cache %s: hits=%d misses=%d evictions=%d entries=%d",
+					name, stats.Hits, stats.Misses, stats.Evictions, stats.Entries)
+			}
+		}
+	}
+}
+
+// get returns a value from memory, the backend is queried on a miss
+func (c *Cache) get(cache *lru, key string, m *flapdb.Model, method func(repository.Cache) func(*flapdb.Model) (*flapdb.CachedValue, error)) (*flapdb.CachedValue, error) {
+
+	if value, age, ok := cache.get(key); ok {
+		return &flapdb.CachedValue{Value: value, Age: age}, nil
+	}
+
+	if c.backend == nil {
+		return nil, repository.ErrCacheMiss
+	}
+
+	value, err := method(c.backend)(m)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.put(key, value.Value, value.Age)
+	return value, nil
+}
+
+func interfaceKey(ip net.IP, ifIndex int) string {
+	return fmt.Sprintf("%s/%d", ip, ifIndex)
+}
@@ -0,0 +1,85 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"testing"
+	"time"
+)
+
+func TestPutCachedIfNamesReplaces(t *testing.T) {
+
+	c := New(nil, Config{Size: 100, Policy: repository.CachePolicy{IfNameTTL: time.Hour, IfAliasTTL: time.Hour}})
+	ctx := context.Background()
+	device, other := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.10")
+
+	if err := c.PutCachedIfNames(ctx, device, map[int]string{1: "Gi0/1", 2: "Gi0/2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutCachedIfNames(ctx, other, map[int]string{2: "eth2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutCachedIfNames(ctx, device, map[int]string{1: "Gi0/2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      net.IP
+		ifIndex int
+		want    string
+	}{
+		{device, 1, "Gi0/2"},
+		{device, 2, ""},
+		{other, 2, "eth2"},
+	}
+	for _, tt := range tests {
+		value, err := c.GetCachedIfName(&flapdb.Model{IpAddress: tt.ip, IfIndex: tt.ifIndex})
+		if tt.want == "" {
+			if !errors.Is(err, repository.ErrCacheMiss) {
+				t.Errorf("%s/%d = %v, %v, want a cache miss", tt.ip, tt.ifIndex, value, err)
+			}
+			continue
+		}
+		if err != nil || value.Value != tt.want {
+			t.Errorf("%s/%d = %v, %v, want %q", tt.ip, tt.ifIndex, value, err, tt.want)
+		}
+	}
+}
+
+// shared is a backend shared by instances, another instance invalidates it
+type shared struct {
+	repository.Cache
+	ifNames map[int]string
+}
+
+func (s *shared) GetCachedIfName(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	if value, ok := s.ifNames[m.IfIndex]; ok {
+		return &flapdb.CachedValue{Value: value}, nil
+	}
+	return nil, repository.ErrCacheMiss
+}
+
+func TestHoldInFrontOfSharedBackend(t *testing.T) {
+
+	backend := &shared{ifNames: map[int]string{1: "Gi0/1"}}
+	c := New(backend, Config{Size: 100, Policy: repository.CachePolicy{IfNameTTL: time.Hour}, Hold: time.Minute})
+	m := &flapdb.Model{IpAddress: net.ParseIP("192.0.2.1"), IfIndex: 1}
+
+	if value, err := c.GetCachedIfName(m); err != nil || value.Value != "Gi0/1" {
+		t.Fatalf("GetCachedIfName = %v, %v, want Gi0/1", value, err)
+	}
+
+	// Another instance invalidates the device
+	delete(backend.ifNames, 1)
+	if _, err := c.GetCachedIfName(m); err != nil {
+		t.Errorf("value held in memory: %v", err)
+	}
+
+	c.ifNames.items[interfaceKey(m.IpAddress, 1)].Value.(*lruItem).putAt = time.Now().Add(-time.Minute * 2)
+	if value, err := c.GetCachedIfName(m); !errors.Is(err, repository.ErrCacheMiss) {
+		t.Errorf("GetCachedIfName after the hold = %v, %v, want a cache miss", value, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package rediscache is a repository.Cache stored in Redis, so it is shared between snmpflapd instances.
+// Values expire by Redis TTL.
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultPrefix  = "snmpflapd:"
+	requestTimeout = time.Second * 3
+	scanCount      = 1000
+)
+
+// Config holds Redis connection settings
+type Config struct {
+	Address  string
+	Password string
+	DB       int
+	// Prefix of every key, "snmpflapd:" when empty
+	Prefix string
+	Policy repository.CachePolicy
+}
+
+// Cache is a repository.Cache stored in Redis
+type Cache struct {
+	client *redis.Client
+	prefix string
+	// retention of values by kind
+	retention map[string]time.Duration
+}
+
+var _ repository.Cache = &Cache{}
+
+// Open connects to Redis
+func Open(cfg Config) (*Cache, error) {
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Address,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+
+	return &Cache{
+		client: client,
+		prefix: prefix,
+		retention: map[string]time.Duration{
+			"hostname": cfg.Policy.Retention(cfg.Policy.HostnameTTL),
+			"ifname":   cfg.Policy.Retention(cfg.Policy.IfNameTTL),
+			"ifalias":  cfg.Policy.Retention(cfg.Policy.IfAliasTTL),
+		},
+	}, nil
+}
+
+// CleanUp does nothing, Redis expires values itself
+func (c *Cache) CleanUp(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the connection
+func (c *Cache) Close() {
+	c.client.Close()
+}
+
+func (c *Cache) GetCachedIfName(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get("ifname", c.interfaceKey("ifname", m.IpAddress, m.IfIndex))
+}
+
+func (c *Cache) PutCachedIfName(ctx context.Context, m *flapdb.Model) error {
+	return c.put(ctx, "ifname", c.interfaceKey("ifname", m.IpAddress, m.IfIndex), m.IfName)
+}
+
+func (c *Cache) GetCachedIfAlias(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get("ifalias", c.interfaceKey("ifalias", m.IpAddress, m.IfIndex))
+}
+
+func (c *Cache) PutCachedIfAlias(ctx context.Context, m *flapdb.Model) error {
+	return c.put(ctx, "ifalias", c.interfaceKey("ifalias", m.IpAddress, m.IfIndex), m.IfAlias)
+}
+
+func (c *Cache) GetCachedHostname(m *flapdb.Model) (*flapdb.CachedValue, error) {
+	return c.get("hostname", c.prefix+"hostname:"+m.IpAddress.String())
+}
+
+func (c *Cache) PutCachedHostname(ctx context.Context, m *flapdb.Model) error {
+	return c.put(ctx, "hostname", c.prefix+"hostname:"+m.IpAddress.String(), m.HostName)
+}
+
+func (c *Cache) PutCachedIfNames(ctx context.Context, ip net.IP, ifNames map[int]string) error {
+	return c.replaceDevice(ctx, "ifname", ip, ifNames)
+}
+
+func (c *Cache) PutCachedIfAliases(ctx context.Context, ip net.IP, ifAliases map[int]string) error {
+	return c.replaceDevice(ctx, "ifalias", ip, ifAliases)
+}
+
+// get returns a value, its age is derived from the remaining TTL of the key
+func (c *Cache) get(kind, key string) (*flapdb.CachedValue, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		ttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	age := c.retention[kind] - ttl.Val()
+	if age < 0 {
+		age = 0
+	}
+	return &flapdb.CachedValue{Value: get.Val(), Age: age}, nil
+}
+
+func (c *Cache) put(ctx context.Context, kind, key string, value *string) error {
+	if value == nil {
+		return nil
+	}
+	return c.client.Set(ctx, key, *value, c.retention[kind]).Err()
+}
+
+// InvalidateDevice deletes cached ifNames and ifAliases of a device
+func (c *Cache) InvalidateDevice(ctx context.Context, ip net.IP) error {
+
+	var keys []string
+	for _, kind := range []string{"ifname", "ifalias"} {
+		kindKeys, err := c.scan(ctx, c.devicePattern(kind, ip))
+		if err != nil {
+			return err
+		}
+		keys = append(keys, kindKeys...)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
+
+// replaceDevice deletes cached values of a device and stores the new ones in one pipeline
+func (c *Cache) replaceDevice(ctx context.Context, kind string, ip net.IP, values map[int]string) error {
+
+	stale, err := c.scan(ctx, c.devicePattern(kind, ip))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(stale) > 0 {
+			pipe.Del(ctx, stale...)
+		}
+		for ifIndex, value := range values {
+			pipe.Set(ctx, c.interfaceKey(kind, ip, ifIndex), value, c.retention[kind])
+		}
+		return nil
+	})
+	return err
+}
+
+// scan returns every key matching the pattern
+func (c *Cache) scan(ctx context.Context, pattern string) ([]string, error) {
+
+	var keys []string
+	iter := c.client.Scan(ctx, 0, pattern, scanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// interfaceKey separates ifIndex with "/", which unlike ":" can't be a part of an IPv6 address
+func (c *Cache) interfaceKey(kind string, ip net.IP, ifIndex int) string {
+	return fmt.Sprintf("%s%s:%s/%d", c.prefix, kind, ip, ifIndex)
+}
+
+// devicePattern matches interface keys of the device only, not of an IPv6 address it is a prefix of
+func (c *Cache) devicePattern(kind string, ip net.IP) string {
+	return c.prefix + kind + ":" + ip.String() + "/*"
+}
@@ -0,0 +1,198 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+var device = net.ParseIP("192.0.2.1")
+
+func openCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+
+	server := miniredis.RunT(t)
+	c, err := Open(Config{
+		Address: server.Addr(),
+		Policy: repository.CachePolicy{
+			HostnameTTL: time.Hour,
+			IfNameTTL:   time.Minute * 10,
+			IfAliasTTL:  time.Minute * 10,
+			StaleTTL:    time.Minute * 5,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, server
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestGetPut(t *testing.T) {
+
+	c, server := openCache(t)
+	ctx := context.Background()
+
+	m := &flapdb.Model{IpAddress: device, IfIndex: 3, HostName: stringPtr("core-1"), IfName: stringPtr("Gi0/3"), IfAlias: stringPtr("uplink")}
+	for _, put := range []func(context.Context, *flapdb.Model) error{c.PutCachedHostname, c.PutCachedIfName, c.PutCachedIfAlias} {
+		if err := put(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nil values are not stored
+	if err := c.PutCachedIfName(ctx, &flapdb.Model{IpAddress: device, IfIndex: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(time.Minute)
+
+	tests := []struct {
+		name    string
+		get     func(*flapdb.Model) (*flapdb.CachedValue, error)
+		ifIndex int
+		want    string
+		wantAge time.Duration
+	}{
+		{"hostname", c.GetCachedHostname, 3, "core-1", time.Minute},
+		{"ifName", c.GetCachedIfName, 3, "Gi0/3", time.Minute},
+		{"ifAlias", c.GetCachedIfAlias, 3, "uplink", time.Minute},
+		{"ifName of another interface", c.GetCachedIfName, 4, "", 0},
+	}
+	for _, tt := range tests {
+		value, err := tt.get(&flapdb.Model{IpAddress: device, IfIndex: tt.ifIndex})
+		if tt.want == "" {
+			if !errors.Is(err, repository.ErrCacheMiss) {
+				t.Errorf("%s: got %v, %v, want a cache miss", tt.name, value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if value.Value != tt.want || value.Age != tt.wantAge {
+			t.Errorf("%s = %q aged %s, want %q aged %s", tt.name, value.Value, value.Age, tt.want, tt.wantAge)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+
+	c, server := openCache(t)
+	ctx := context.Background()
+
+	m := &flapdb.Model{IpAddress: device, IfIndex: 3, IfName: stringPtr("Gi0/3")}
+	if err := c.PutCachedIfName(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stale values are kept until the TTL and the stale period have passed
+	server.FastForward(time.Minute * 14)
+	if value, err := c.GetCachedIfName(m); err != nil || value.Age != time.Minute*14 {
+		t.Fatalf("GetCachedIfName = %v, %v, want a stale value", value, err)
+	}
+
+	server.FastForward(time.Minute)
+	if value, err := c.GetCachedIfName(m); !errors.Is(err, repository.ErrCacheMiss) {
+		t.Fatalf("GetCachedIfName = %v, %v, want a cache miss", value, err)
+	}
+}
+
+func TestPutCachedIfNames(t *testing.T) {
+
+	c, _ := openCache(t)
+	ctx := context.Background()
+	other := net.ParseIP("192.0.2.2")
+
+	if err := c.PutCachedIfNames(ctx, device, map[int]string{1: "Gi0/1", 2: "Gi0/2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutCachedIfNames(ctx, other, map[int]string{1: "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The device has renumbered: ifIndex 2 is gone
+	if err := c.PutCachedIfNames(ctx, device, map[int]string{1: "Gi0/2", 3: "Gi0/3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      net.IP
+		ifIndex int
+		want    string
+	}{
+		{device, 1, "Gi0/2"},
+		{device, 2, ""},
+		{device, 3, "Gi0/3"},
+		{other, 1, "eth0"},
+	}
+	for _, tt := range tests {
+		value, err := c.GetCachedIfName(&flapdb.Model{IpAddress: tt.ip, IfIndex: tt.ifIndex})
+		if tt.want == "" {
+			if !errors.Is(err, repository.ErrCacheMiss) {
+				t.Errorf("%s/%d = %v, %v, want a cache miss", tt.ip, tt.ifIndex, value, err)
+			}
+			continue
+		}
+		if err != nil || value.Value != tt.want {
+			t.Errorf("%s/%d = %v, %v, want %q", tt.ip, tt.ifIndex, value, err, tt.want)
+		}
+	}
+
+	if err := c.InvalidateDevice(ctx, device); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetCachedIfName(&flapdb.Model{IpAddress: device, IfIndex: 1}); !errors.Is(err, repository.ErrCacheMiss) {
+		t.Errorf("ifName of an invalidated device: %v, want a cache miss", err)
+	}
+	if _, err := c.GetCachedIfName(&flapdb.Model{IpAddress: other, IfIndex: 1}); err != nil {
+		t.Errorf("ifName of another device: %v", err)
+	}
+}
+
+func TestIPv6Devices(t *testing.T) {
+
+	c, _ := openCache(t)
+	ctx := context.Background()
+
+	// The keys of short would match a pattern of long if ":" separated ifIndex
+	short, long := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::1:5")
+
+	for _, ip := range []net.IP{short, long} {
+		if err := c.PutCachedIfNames(ctx, ip, map[int]string{7: "Gi0/7"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.PutCachedIfAlias(ctx, &flapdb.Model{IpAddress: ip, IfIndex: 7, IfAlias: stringPtr("uplink")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.PutCachedIfNames(ctx, short, map[int]string{8: "Gi0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetCachedIfName(&flapdb.Model{IpAddress: long, IfIndex: 7}); err != nil {
+		t.Errorf("ifName of %s after a walk of %s: %v", long, short, err)
+	}
+
+	if err := c.InvalidateDevice(ctx, short); err != nil {
+		t.Fatal(err)
+	}
+	for _, get := range []func(*flapdb.Model) (*flapdb.CachedValue, error){c.GetCachedIfName, c.GetCachedIfAlias} {
+		if _, err := get(&flapdb.Model{IpAddress: short, IfIndex: 7}); !errors.Is(err, repository.ErrCacheMiss) {
+			t.Errorf("value of invalidated %s: %v, want a cache miss", short, err)
+		}
+		if _, err := get(&flapdb.Model{IpAddress: long, IfIndex: 7}); err != nil {
+			t.Errorf("value of %s after invalidating %s: %v", long, short, err)
+		}
+	}
+}
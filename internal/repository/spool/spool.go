@@ -0,0 +1,505 @@
+// Package spool keeps link events on disk while the database is unavailable.
+// A failed write goes to an append-only, fsynced and size-capped file, and so does every write after it,
+// until the replayer has drained the file to the database in order.
+// Records the database rejects for their values are moved to a quarantine file instead,
+// so they don't hold back the records after them.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opSave   = "save"
+	opUpdate = "update"
+
+	// replayBatchSize is the maximum number of events inserted at once on replay
+	replayBatchSize = 500
+)
+
+// ErrFull is returned when the spool has reached its size limit
+var ErrFull = errors.New("spool is full")
+
+// Config holds the spool settings
+type Config struct {
+	// Path is the spool file, the read offset is kept next to it in Path.offset
+	// and rejected records are appended to Path.rejected
+	Path string
+	// MaxBytes caps the spool file size
+	MaxBytes int64
+}
+
+// record is a line of the spool file
+type record struct {
+	Op    string        `json:"op"`
+	Event *flapdb.Model `json:"event"`
+	// end is the offset following the record in the spool file, set by read
+	end int64
+}
+
+// rejectedRecord is a line of the quarantine file
+type rejectedRecord struct {
+	record
+	Error string `json:"error"`
+}
+
+// Spool is a repository.Connector falling back to the spool file when the database write fails
+type Spool struct {
+	repository.Connector
+
+	path     string
+	maxBytes int64
+
+	mx       sync.Mutex
+	file     *os.File
+	size     int64
+	offset   int64
+	depth    int
+	rejected int64
+}
+
+var _ repository.Connector = &Spool{}
+
+// Open opens or creates the spool file in front of the connector
+func Open(connector repository.Connector, cfg Config) (*Spool, error) {
+
+	file, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		Connector: connector,
+		path:      cfg.Path,
+		maxBytes:  cfg.MaxBytes,
+		file:      file,
+	}
+
+	if err := s.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if s.depth > 0 {
+		log.Printf("Spool %s has %d records to replay", s.path, s.depth)
+	}
+	return s, nil
+}
+
+// recover reads the offset, drops a partially written last record and counts the records to replay
+func (s *Spool) recover() error {
+
+	if data, err := os.ReadFile(s.offsetPath()); err == nil {
+		if s.offset, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return fmt.Errorf("wrong spool offset file: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if _, err := s.file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(s.file)
+	end := s.offset
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		end += int64(len(line))
+		s.depth++
+	}
+
+	// A record without the trailing newline was being written when the process stopped
+	if err := s.file.Truncate(end); err != nil {
+		return err
+	}
+	s.size = end
+	return nil
+}
+
+func (s *Spool) offsetPath() string {
+	return s.path + ".offset"
+}
+
+// Depth returns the number of records waiting for replay
+func (s *Spool) Depth() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.depth
+}
+
+// Bytes returns the size of the spool file
+func (s *Spool) Bytes() int64 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.size
+}
+
+// Rejected returns the number of records quarantined since start
+func (s *Spool) Rejected() int64 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.rejected
+}
+
+// Close closes the spool file, the connector is closed by its owner
+func (s *Spool) Close() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.file.Close()
+}
+
+func (s *Spool) SaveLinkEvent(m *flapdb.Model) error {
+	return s.SaveLinkEvents(context.Background(), []*flapdb.Model{m})
+}
+
+// SaveLinkEvents writes the events to the database, or to the spool if it is not empty or the write fails.
+// Events rejected by the database for their values are quarantined, the others are saved.
+func (s *Spool) SaveLinkEvents(ctx context.Context, events []*flapdb.Model) error {
+
+	records := make([]record, 0, len(events))
+	for _, m := range events {
+		records = append(records, record{Op: opSave, Event: m})
+	}
+
+	if s.Depth() == 0 {
+		err := s.Connector.SaveLinkEvents(ctx, events)
+		if err == nil {
+			return nil
+		}
+
+		if flapdb.IsDataError(err) {
+			log.Printf("%d link events rejected by the database, saving them one by one: %s", len(events), err)
+			var done int
+			done, err = s.saveEach(ctx, records)
+			if err == nil {
+				return nil
+			}
+			records = records[done:]
+		}
+		log.Printf("unable to save %d link events, spooling them: %s", len(records), err)
+	}
+
+	return s.append(records)
+}
+
+// UpdateLinkEvent updates the event in the database, or spools the update after the event.
+// An update rejected by the database for its values is quarantined.
+func (s *Spool) UpdateLinkEvent(m *flapdb.Model) error {
+
+	if s.Depth() == 0 {
+		err := s.Connector.UpdateLinkEvent(m)
+		if err == nil {
+			return nil
+		}
+		if flapdb.IsDataError(err) {
+			s.reject(record{Op: opUpdate, Event: m}, err)
+			return err
+		}
+		log.Println(m.Sid, "unable to update link event, spooling the update:", err)
+	}
+
+	return s.append([]record{{Op: opUpdate, Event: m}})
+}
+
+// saveEach saves the events of the records one at a time and quarantines those the database rejects.
+// It stops at a failure of another kind and returns the number of records done before it.
+func (s *Spool) saveEach(ctx context.Context, records []record) (int, error) {
+
+	for i, r := range records {
+		err := s.Connector.SaveLinkEvents(ctx, []*flapdb.Model{r.Event})
+		if err == nil {
+			continue
+		}
+		if !flapdb.IsDataError(err) {
+			return i, err
+		}
+		s.reject(r, err)
+	}
+	return len(records), nil
+}
+
+// reject appends the record to the quarantine file. The record is dropped if it can't be written there.
+func (s *Spool) reject(r record, reason error) {
+
+	log.Printf("%s link event rejected by the database, quarantined in %s: %s", r.Event.Sid, s.rejectedPath(), reason)
+
+	line, err := json.Marshal(rejectedRecord{record: r, Error: reason.Error()})
+	if err != nil {
+		log.Println(r.Event.Sid, "unable to quarantine the link event:", err)
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.rejected++
+
+	file, err := os.OpenFile(s.rejectedPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Println(r.Event.Sid, "unable to quarantine the link event:", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Println(r.Event.Sid, "unable to quarantine the link event:", err)
+	}
+}
+
+func (s *Spool) rejectedPath() string {
+	return s.path + ".rejected"
+}
+
+// append writes records and syncs the file
+func (s *Spool) append(records []record) error {
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.maxBytes > 0 && s.size+int64(buf.Len()) > s.maxBytes {
+		// The caller keeps or drops them, the write buffer keeps saved events for its next flush
+		log.Printf("spool %s is full, %d records not spooled", s.path, len(records))
+		return ErrFull
+	}
+
+	n, err := s.file.WriteAt(buf.Bytes(), s.size)
+	if err != nil {
+		// Whatever was written partially is overwritten by the next append
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.size += int64(n)
+	s.depth += len(records)
+	return nil
+}
+
+// Run replays the spool every period until the context is done
+func (s *Spool) Run(ctx context.Context, period time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("closed due context")
+			return
+		case <-time.After(period):
+			if s.Depth() == 0 {
+				continue
+			}
+			if err := s.Replay(ctx); err != nil {
+				log.Println("unable to replay spool:", err)
+			}
+		}
+	}
+}
+
+// Replay writes spooled records to the database in order until the spool is empty or a write fails.
+// Records rejected by the database for their values are quarantined and skipped.
+func (s *Spool) Replay(ctx context.Context) error {
+
+	replayed := 0
+	defer func() {
+		if replayed > 0 {
+			log.Printf("Spool replayed %d records, %d left", replayed, s.Depth())
+		}
+	}()
+
+	for {
+		records, next, err := s.read()
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		done, err := s.replay(ctx, records)
+		if done > 0 {
+			end := next
+			if done < len(records) {
+				end = records[done-1].end
+			}
+			if err := s.advance(end, done); err != nil {
+				return err
+			}
+			replayed += done
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replay writes the records read at once to the database and returns the number of records done:
+// written or quarantined. The records after a failure are left for the next replay.
+func (s *Spool) replay(ctx context.Context, records []record) (int, error) {
+
+	if records[0].Op == opUpdate {
+		err := s.Connector.UpdateLinkEvent(records[0].Event)
+		if err != nil && !flapdb.IsDataError(err) {
+			return 0, err
+		}
+		if err != nil {
+			s.reject(records[0], err)
+		}
+		return 1, nil
+	}
+
+	events := make([]*flapdb.Model, 0, len(records))
+	for _, r := range records {
+		events = append(events, r.Event)
+	}
+	err := s.Connector.SaveLinkEvents(ctx, events)
+	if err == nil {
+		return len(records), nil
+	}
+	if !flapdb.IsDataError(err) {
+		return 0, err
+	}
+
+	log.Printf("%d spooled link events rejected by the database, replaying them one by one: %s", len(records), err)
+	return s.saveEach(ctx, records)
+}
+
+// read returns the records to replay next: a single update, or consecutive saves up to replayBatchSize,
+// and the offset following them
+func (s *Spool) read() ([]record, int64, error) {
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	next := s.offset
+
+	var records []record
+	for len(records) < replayBatchSize {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil || r.Event == nil {
+			log.Printf("spool %s: skipping a broken record at offset %d", s.path, next)
+			next += int64(len(line))
+			s.depth--
+			continue
+		}
+
+		if r.Op == opUpdate && len(records) > 0 {
+			break
+		}
+		next += int64(len(line))
+		r.end = next
+		records = append(records, r)
+		if r.Op == opUpdate {
+			break
+		}
+	}
+
+	if len(records) == 0 && next != s.offset {
+		return nil, 0, s.setOffset(next)
+	}
+	return records, next, nil
+}
+
+// advance marks records as replayed. The file is truncated once everything is replayed.
+func (s *Spool) advance(next int64, count int) error {
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.depth -= count
+	return s.setOffset(next)
+}
+
+func (s *Spool) setOffset(offset int64) error {
+
+	if offset == s.size {
+		if err := s.file.Truncate(0); err != nil {
+			return err
+		}
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+		offset, s.size, s.depth = 0, 0, 0
+	}
+
+	if err := writeFileSynced(s.offsetPath(), []byte(strconv.FormatInt(offset, 10))); err != nil {
+		return err
+	}
+
+	s.offset = offset
+	return nil
+}
+
+// writeFileSynced replaces the file atomically. The data and the rename are synced,
+// so records already replayed are not replayed again after a crash.
+func writeFileSynced(path string, data []byte) error {
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,253 @@
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var errDown = errors.New("dial tcp 127.0.0.1:3306: connect: connection refused")
+
+// database is a repository.Connector writing to memory. A write of a rejected sid fails as a whole.
+type database struct {
+	repository.Connector
+	down     bool
+	rejected map[string]bool
+	saved    []string
+	updated  []string
+}
+
+func (d *database) SaveLinkEvents(ctx context.Context, events []*flapdb.Model) error {
+	if d.down {
+		return errDown
+	}
+	for _, m := range events {
+		if d.rejected[m.Sid] {
+			return &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'ifAlias' at row 1"}
+		}
+	}
+	for _, m := range events {
+		d.saved = append(d.saved, m.Sid)
+	}
+	return nil
+}
+
+func (d *database) UpdateLinkEvent(m *flapdb.Model) error {
+	if d.down {
+		return errDown
+	}
+	if d.rejected[m.Sid] {
+		return &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'ifAlias' at row 1"}
+	}
+	d.updated = append(d.updated, m.Sid)
+	return nil
+}
+
+func events(sids ...string) []*flapdb.Model {
+	var events []*flapdb.Model
+	for _, sid := range sids {
+		events = append(events, &flapdb.Model{Sid: sid})
+	}
+	return events
+}
+
+func open(t *testing.T, db *database, path string) *Spool {
+	s, err := Open(db, Config{Path: path, MaxBytes: 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func checkWritten(t *testing.T, db *database, saved, updated []string) {
+	t.Helper()
+	if !reflect.DeepEqual(db.saved, saved) {
+		t.Errorf("saved %v, want %v", db.saved, saved)
+	}
+	if !reflect.DeepEqual(db.updated, updated) {
+		t.Errorf("updated %v, want %v", db.updated, updated)
+	}
+}
+
+func TestReplayInOrder(t *testing.T) {
+
+	db := &database{down: true}
+	s := open(t, db, filepath.Join(t.TempDir(), "spool.jsonl"))
+	ctx := context.Background()
+
+	if err := s.SaveLinkEvents(ctx, events("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateLinkEvent(&flapdb.Model{Sid: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is back, but the spool is not empty
+	db.down = false
+	if err := s.SaveLinkEvent(&flapdb.Model{Sid: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Depth() != 4 {
+		t.Fatalf("depth %d, want 4", s.Depth())
+	}
+	checkWritten(t, db, nil, nil)
+
+	if err := s.Replay(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, db, []string{"a", "b", "c"}, []string{"a"})
+	if s.Depth() != 0 || s.Bytes() != 0 {
+		t.Errorf("depth %d and %d bytes after replay, want an empty spool", s.Depth(), s.Bytes())
+	}
+
+	// Writes go to the database again
+	if err := s.SaveLinkEvent(&flapdb.Model{Sid: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, db, []string{"a", "b", "c", "d"}, []string{"a"})
+}
+
+func TestRecover(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+	db := &database{down: true}
+	s := open(t, db, path)
+	ctx := context.Background()
+
+	if err := s.SaveLinkEvent(&flapdb.Model{Sid: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateLinkEvent(&flapdb.Model{Sid: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveLinkEvent(&flapdb.Model{Sid: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is back but fails the update, after the first record is replayed
+	first := &database{}
+	s.Connector = &updateDown{database: first}
+	if err := s.Replay(ctx); !errors.Is(err, errDown) {
+		t.Fatalf("Replay error %v, want %v", err, errDown)
+	}
+	checkWritten(t, first, []string{"a"}, nil)
+	s.Close()
+
+	// The process stopped in the middle of a write
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"op":"save","event":{"Sid":"c"`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db.down = false
+	restarted := open(t, db, path)
+	if restarted.Depth() != 2 {
+		t.Fatalf("depth %d after restart, want 2", restarted.Depth())
+	}
+	if err := restarted.Replay(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, db, []string{"b"}, []string{"a"})
+}
+
+// updateDown is a database failing updates
+type updateDown struct {
+	*database
+}
+
+func (d *updateDown) UpdateLinkEvent(m *flapdb.Model) error {
+	return errDown
+}
+
+func TestRejected(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+	db := &database{rejected: map[string]bool{"bad": true, "bad-update": true, "bad-spooled": true}}
+	s := open(t, db, path)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		write   func() error
+		saved   []string
+		updated []string
+	}{
+		{"a rejected event doesn't fail the batch", func() error {
+			return s.SaveLinkEvents(ctx, events("a", "bad", "b"))
+		}, []string{"a", "b"}, nil},
+		{"a rejected update is not spooled", func() error {
+			if err := s.UpdateLinkEvent(&flapdb.Model{Sid: "bad-update"}); !flapdb.IsDataError(err) {
+				t.Errorf("UpdateLinkEvent error %v, want the data error", err)
+			}
+			return nil
+		}, []string{"a", "b"}, nil},
+		{"a rejected event doesn't stop the replay", func() error {
+			db.down = true
+			if err := s.SaveLinkEvents(ctx, events("c", "bad-spooled", "d")); err != nil {
+				return err
+			}
+			if err := s.UpdateLinkEvent(&flapdb.Model{Sid: "bad-spooled"}); err != nil {
+				return err
+			}
+			if err := s.UpdateLinkEvent(&flapdb.Model{Sid: "c"}); err != nil {
+				return err
+			}
+			db.down = false
+			return s.Replay(ctx)
+		}, []string{"a", "b", "c", "d"}, []string{"c"}},
+	}
+	for _, tt := range tests {
+		if err := tt.write(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if s.Depth() != 0 {
+			t.Errorf("%s: depth %d, want 0", tt.name, s.Depth())
+		}
+		checkWritten(t, db, tt.saved, tt.updated)
+	}
+
+	if s.Rejected() != 4 {
+		t.Errorf("%d records rejected, want 4", s.Rejected())
+	}
+
+	f, err := os.Open(path + ".rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var quarantined []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r struct {
+			Op    string
+			Event flapdb.Model
+			Error string
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Error == "" {
+			t.Errorf("%s quarantined without the error", r.Event.Sid)
+		}
+		quarantined = append(quarantined, r.Op+" "+r.Event.Sid)
+	}
+	want := []string{"save bad", "update bad-update", "save bad-spooled", "update bad-spooled"}
+	if !reflect.DeepEqual(quarantined, want) {
+		t.Errorf("quarantined %v, want %v", quarantined, want)
+	}
+}
@@ -4,16 +4,21 @@ import (
 	"context"
 	"log"
 	"snmpflapd/internal/repository"
+	"snmpflapd/internal/services/leader"
 	"time"
 )
 
-func RunDBCleanUp(ctx context.Context, repo repository.Connector, period time.Duration) {
+// RunDBCleanUp deletes expired cached values every period, while the instance is the leader
+func RunDBCleanUp(ctx context.Context, repo repository.Cache, elector *leader.Elector, period time.Duration) {
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("closed due context")
 			return
 		case <-time.After(period):
+			if !elector.IsLeader() {
+				continue
+			}
 			if err := repo.CleanUp(ctx); err != nil {
 				log.Println(err)
 			}
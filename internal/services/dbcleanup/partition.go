@@ -0,0 +1,35 @@
+package dbcleanup
+
+import (
+	"context"
+	"log"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/services/leader"
+	"time"
+)
+
+// RunPartitionMaintenance keeps partitions created ahead of time, at start and every period after that.
+// Expired partitions are dropped by the retention policy. Only the leader maintains partitions.
+func RunPartitionMaintenance(ctx context.Context, repo repository.PartitionManager, elector *leader.Elector, by string, ahead int, period time.Duration) {
+
+	if elector.IsLeader() {
+		if err := repo.MaintainPartitions(ctx, by, ahead); err != nil {
+			log.Println("unable to maintain partitions:", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("closed due context")
+			return
+		case <-time.After(period):
+			if !elector.IsLeader() {
+				continue
+			}
+			if err := repo.MaintainPartitions(ctx, by, ahead); err != nil {
+				log.Println("unable to maintain partitions:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package dbcleanup
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/services/leader"
+	"time"
+)
+
+// Archive modes of purged link events
+const (
+	ArchiveNone  = ""
+	ArchiveTable = "table"
+	ArchiveFile  = "file"
+)
+
+// RetentionPolicy tells how long link events and their rollups are kept
+type RetentionPolicy struct {
+	// Events is the age raw events are purged at, 0 keeps them forever
+	Events time.Duration
+	// Hourly is the age hourly rollups are purged at, 0 keeps them forever. Daily rollups are kept forever.
+	Hourly time.Duration
+	// Archive is where purged events go: nowhere, the archive tables or gzipped JSON lines files in ArchiveDir
+	Archive    string
+	ArchiveDir string
+	// DedupWindow is how long dedup keys of stored events are kept, 0 if events aren't deduplicated
+	DedupWindow time.Duration
+}
+
+// Validate checks the archive mode
+func (p RetentionPolicy) Validate() error {
+	switch p.Archive {
+	case ArchiveNone, ArchiveTable, ArchiveFile:
+		return nil
+	}
+	return fmt.Errorf("unknown archive mode %q", p.Archive)
+}
+
+// RunRetention rolls up link events and applies the retention policy every period, while the instance is the leader
+func RunRetention(ctx context.Context, repo repository.EventRetention, elector *leader.Elector, policy RetentionPolicy, period time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("closed due context")
+			return
+		case <-time.After(period):
+			if !elector.IsLeader() {
+				continue
+			}
+			if err := ApplyRetention(ctx, repo, policy); err != nil {
+				log.Println("unable to apply retention policy:", err)
+			}
+		}
+	}
+}
+
+// ApplyRetention rolls up link events, then purges events and hourly rollups older than the policy allows.
+// Events not rolled up yet are never purged.
+func ApplyRetention(ctx context.Context, repo repository.EventRetention, policy RetentionPolicy) error {
+
+	now := time.Now()
+	if policy.DedupWindow > 0 {
+		if _, err := repo.PurgeDedupKeys(ctx, now.Add(-policy.DedupWindow)); err != nil {
+			return err
+		}
+	}
+
+	rolledUp, err := rollUp(ctx, repo, now)
+	if err != nil {
+		return err
+	}
+	if rolledUp.IsZero() {
+		return nil
+	}
+
+	if policy.Hourly > 0 {
+		// Daily rollups of the latest day are counted again from its hourly rollups
+		before := now.Add(-policy.Hourly)
+		if day := time.Date(rolledUp.Year(), rolledUp.Month(), rolledUp.Day(), 0, 0, 0, 0, time.Local); day.Before(before) {
+			before = day
+		}
+		purged, err := repo.PurgeHourlyRollups(ctx, before)
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			log.Printf("Retention: %d hourly rollups purged", purged)
+		}
+	}
+
+	if policy.Events == 0 {
+		return nil
+	}
+
+	before := now.Add(-policy.Events)
+	if rolledUp.Before(before) {
+		before = rolledUp
+	}
+
+	if policy.Archive == ArchiveFile {
+		archived, err := archiveToFile(ctx, repo, before, policy.ArchiveDir)
+		if err != nil {
+			return err
+		}
+		if archived == 0 {
+			return nil
+		}
+	}
+
+	purged, err := repo.PurgeEvents(ctx, before, policy.Archive == ArchiveTable)
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Retention: %d link events older than %s purged", purged, before.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// rollUp rolls up link events until there is nothing more to roll up and returns the time they are rolled up before
+func rollUp(ctx context.Context, repo repository.EventRetention, until time.Time) (time.Time, error) {
+
+	var rolledUp time.Time
+	for {
+		next, err := repo.RollUpEvents(ctx, until)
+		if err != nil {
+			return rolledUp, err
+		}
+		if !next.After(rolledUp) {
+			return rolledUp, nil
+		}
+		rolledUp = next
+
+		if ctx.Err() != nil {
+			return rolledUp, ctx.Err()
+		}
+	}
+}
+
+// archiveToFile writes link events older than the time to a new gzipped file in the directory.
+// The file is synced before it is renamed to its final name, so events are purged only once they are on disk.
+func archiveToFile(ctx context.Context, repo repository.EventRetention, before time.Time, dir string) (int, error) {
+
+	name := filepath.Join(dir, fmt.Sprintf("ports-%s.jsonl.gz", before.Format("20060102-150405")))
+	tmp := name + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	archived, err := repo.ArchiveEvents(ctx, before, zw)
+	if err != nil {
+		return 0, err
+	}
+	if archived == 0 {
+		return 0, nil
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Retention: %d link events archived to %s", archived, name)
+	return archived, nil
+}
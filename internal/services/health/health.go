@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// checkTimeout limits every check of a health request
+const checkTimeout = 5 * time.Second
+
+// Config holds what the health output reports
+type Config struct {
+	// Checks report an error when a dependency is unavailable
+	Checks map[string]func(context.Context) error
+	// Gauges are reported as is
+	Gauges map[string]func() int64
+}
+
+// report is the JSON health output
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+	Gauges map[string]int64  `json:"gauges"`
+}
+
+// Run serves the health output on GET /health until the context is done.
+// The status is 503 when a check fails.
+func Run(ctx context.Context, address string, cfg Config) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		checkCtx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		rep := report{Status: "ok", Checks: map[string]string{}, Gauges: map[string]int64{}}
+		for name, check := range cfg.Checks {
+			rep.Checks[name] = "ok"
+			if err := check(checkCtx); err != nil {
+				rep.Checks[name] = err.Error()
+				rep.Status = "fail"
+			}
+		}
+		for name, gauge := range cfg.Gauges {
+			rep.Gauges[name] = gauge()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if rep.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			log.Println("unable to write health output:", err)
+		}
+	})
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: checkTimeout}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println("health server stopped:", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package leader elects one of the instances sharing a database to run maintenance jobs.
+// The leader is the instance holding an advisory lock of the database, it loses leadership with its connection.
+package leader
+
+import (
+	"context"
+	"log"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"sync"
+	"time"
+)
+
+// Elector campaigns for the named lock
+type Elector struct {
+	locker repository.Locker
+	name   string
+
+	mx   sync.Mutex
+	lock *flapdb.Lock
+}
+
+// New returns an Elector for the named lock, it has to campaign before it becomes the leader
+func New(locker repository.Locker, name string) *Elector {
+	return &Elector{locker: locker, name: name}
+}
+
+// IsLeader reports whether the instance holds the lock. A nil Elector is always the leader.
+func (e *Elector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	return e.lock != nil
+}
+
+// Campaign checks the lock is still held, or tries to take it
+func (e *Elector) Campaign(ctx context.Context) {
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	if e.lock != nil {
+		if err := e.lock.Check(ctx); err == nil {
+			return
+		}
+		log.Printf("Leadership of %s lost", e.name)
+		e.lock.Release(ctx)
+		e.lock = nil
+	}
+
+	lock, err := e.locker.TryLock(ctx, e.name)
+	if err != nil {
+		log.Println("unable to campaign for leadership:", err)
+		return
+	}
+	if lock != nil {
+		log.Printf("Leadership of %s taken, maintenance jobs run on this instance", e.name)
+		e.lock = lock
+	}
+}
+
+// Run campaigns every period and releases the lock when the context is done
+func (e *Elector) Run(ctx context.Context, period time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			e.release()
+			log.Println("closed due context")
+			return
+		case <-time.After(period):
+			e.Campaign(ctx)
+		}
+	}
+}
+
+func (e *Elector) release() {
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	if e.lock != nil {
+		e.lock.Release(context.Background())
+		e.lock = nil
+	}
+}
@@ -0,0 +1,89 @@
+// This file is responsible for the cache policy of lookups:
+// failed lookups are not retried for CachePolicy.NegativeTTL and stale cached values
+// are served while they are refreshed in the background.
+
+package linkevent
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// refreshTimeout limits a background refresh, it outlives the event that started it
+const refreshTimeout = time.Minute
+
+var (
+	errNegativeCached = errors.New("lookup failed recently, not retrying yet")
+	lookups           = lookupTracker{failed: map[string]time.Time{}, refreshing: map[string]bool{}}
+)
+
+// lookupTracker remembers failed lookups and refreshes in progress
+type lookupTracker struct {
+	mx         sync.Mutex
+	failed     map[string]time.Time
+	refreshing map[string]bool
+}
+
+// do runs the lookup unless it has failed within the negative TTL
+func (t *lookupTracker) do(key string, negativeTTL time.Duration, lookup func() (*string, error)) (*string, error) {
+
+	if t.failedRecently(key, negativeTTL) {
+		return nil, errNegativeCached
+	}
+
+	value, err := lookup()
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if err != nil {
+		t.failed[key] = time.Now()
+		return nil, err
+	}
+	delete(t.failed, key)
+	return value, nil
+}
+
+func (t *lookupTracker) failedRecently(key string, negativeTTL time.Duration) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	failedAt, ok := t.failed[key]
+	if !ok {
+		return false
+	}
+	if time.Since(failedAt) > negativeTTL {
+		delete(t.failed, key)
+		return false
+	}
+	return true
+}
+
+// refresh runs the refresh in the background unless one is running for the key already.
+// The refresh must not share data with the caller, it gets a context of its own.
+func (t *lookupTracker) refresh(key string, refresh func(context.Context)) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.refreshing[key] {
+		return
+	}
+	t.refreshing[key] = true
+
+	go func() {
+		defer func() {
+			t.mx.Lock()
+			delete(t.refreshing, key)
+			t.mx.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		log.Println("refreshing stale cached value", key)
+		refresh(ctx)
+	}()
+}
@@ -0,0 +1,154 @@
+// This file is responsible for decoding SNMP OCTET STRING values into UTF-8.
+// Older devices send ifAlias and sysName in a legacy charset, some send them hex-encoded,
+// so raw bytes are never converted to a string as is.
+
+package linkevent
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	CharsetUTF8 = "utf-8"
+)
+
+var (
+	charsetEncodings = map[string]encoding.Encoding{
+		"cp1251":       charmap.Windows1251,
+		"windows-1251": charmap.Windows1251,
+		"koi8-r":       charmap.KOI8R,
+		"koi8-u":       charmap.KOI8U,
+		"cp866":        charmap.CodePage866,
+		"latin1":       charmap.ISO8859_1,
+		"iso-8859-1":   charmap.ISO8859_1,
+		"iso-8859-5":   charmap.ISO8859_5,
+	}
+
+	// hexString is a hex dump like "D0 9F D1 80", "d0:9f:d1:80" or "0xd09fd180"
+	hexString = regexp.MustCompile(`^(?:0x[0-9A-Fa-f]{2,}|[0-9A-Fa-f]{2}(?:[ :][0-9A-Fa-f]{2})+)$`)
+)
+
+// CharsetConfig sets the charset of devices sending non UTF-8 strings
+type CharsetConfig struct {
+	// Default is used for devices not listed in Devices, UTF-8 if empty
+	Default string
+	// Devices maps an IP address to a charset
+	Devices map[string]string
+}
+
+// Charsets is a compiled CharsetConfig
+type Charsets struct {
+	defaultEncoding encoding.Encoding
+	devices         map[string]encoding.Encoding
+}
+
+// NewCharsets validates the charset names
+func NewCharsets(cfg CharsetConfig) (*Charsets, error) {
+
+	cs := &Charsets{devices: map[string]encoding.Encoding{}}
+
+	var err error
+	if cs.defaultEncoding, err = charsetEncoding(cfg.Default); err != nil {
+		return nil, err
+	}
+
+	for ip, charset := range cfg.Devices {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("wrong device address %q of charset %q", ip, charset)
+		}
+		if cs.devices[net.ParseIP(ip).String()], err = charsetEncoding(charset); err != nil {
+			return nil, err
+		}
+	}
+
+	return cs, nil
+}
+
+// charsetEncoding returns nil for UTF-8
+func charsetEncoding(charset string) (encoding.Encoding, error) {
+
+	charset = strings.ToLower(charset)
+	if charset == "" || charset == CharsetUTF8 || charset == "utf8" {
+		return nil, nil
+	}
+
+	enc, ok := charsetEncodings[charset]
+	if !ok {
+		return nil, fmt.Errorf("unknown charset %q", charset)
+	}
+	return enc, nil
+}
+
+// Decode converts an OCTET STRING value of a device to valid UTF-8.
+// Hex dumps are decoded to bytes first. Valid UTF-8 is kept as is,
+// anything else is decoded with the device charset, or gets invalid bytes replaced.
+func (cs *Charsets) Decode(ip net.IP, raw []byte) string {
+
+	// Some agents pad strings with NULs
+	raw = []byte(strings.TrimRight(string(raw), "\x00"))
+
+	// Without a charset of the device, a hex dump must decode to UTF-8, "ab cd" stays text
+	if hexString.MatchString(string(raw)) {
+		if decoded, ok := decodeHex(string(raw)); ok && (utf8.Valid(decoded) || cs.encoding(ip) != nil) {
+			raw = decoded
+		}
+	}
+
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	if enc := cs.encoding(ip); enc != nil {
+		if decoded, err := enc.NewDecoder().Bytes(raw); err == nil {
+			return string(decoded)
+		}
+	}
+
+	return strings.ToValidUTF8(string(raw), string(utf8.RuneError))
+}
+
+// encoding returns the charset of a device, nil for UTF-8
+func (cs *Charsets) encoding(ip net.IP) encoding.Encoding {
+
+	if cs == nil {
+		return nil
+	}
+
+	if enc, ok := cs.devices[ip.String()]; ok {
+		return enc
+	}
+	return cs.defaultEncoding
+}
+
+// decodeHex decodes a hex dump. Text that only looks like one, e.g. "10 20 30" or "ab cd",
+// is not decoded: the result must be free of control characters and contain a non-ASCII byte.
+func decodeHex(s string) ([]byte, bool) {
+
+	s = strings.TrimPrefix(s, "0x")
+	s = strings.NewReplacer(" ", "", ":", "").Replace(s)
+
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	decoded = []byte(strings.TrimRight(string(decoded), "\x00"))
+
+	nonASCII := false
+	for _, b := range decoded {
+		if b < 0x20 || b == 0x7f {
+			return nil, false
+		}
+		if b > 0x7f {
+			nonASCII = true
+		}
+	}
+	return decoded, nonASCII
+}
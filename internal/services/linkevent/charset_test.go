@@ -0,0 +1,82 @@
+package linkevent
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+
+	cs, err := NewCharsets(CharsetConfig{
+		Default: "koi8-r",
+		Devices: map[string]string{"192.0.2.1": "cp1251", "192.0.2.2": "utf-8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp1251, koi8r, utf8Device := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.3"), net.ParseIP("192.0.2.2")
+
+	tests := []struct {
+		name string
+		ip   net.IP
+		raw  []byte
+		want string
+	}{
+		{"ASCII", cp1251, []byte("Gi0/1"), "Gi0/1"},
+		{"UTF-8 is kept", cp1251, []byte("Порт"), "Порт"},
+		{"NUL padding", utf8Device, []byte("Gi0/1\x00\x00"), "Gi0/1"},
+		{"device charset", cp1251, []byte{0xcf, 0xee, 0xf0, 0xf2}, "Порт"},
+		{"default charset", koi8r, []byte{0xf0, 0xcf, 0xd2, 0xd4}, "Порт"},
+		{"invalid bytes replaced", utf8Device, []byte{'a', 0xcf, 'b'}, "a�b"},
+		{"hex dump with spaces", utf8Device, []byte("D0 9F D1 80"), "Пр"},
+		{"hex dump with colons", utf8Device, []byte("d0:9f:d1:80"), "Пр"},
+		{"hex dump with prefix", utf8Device, []byte("0xd09fd180"), "Пр"},
+		{"hex dump in the device charset", cp1251, []byte("cf ee f0 f2"), "Порт"},
+		{"numbers are not a hex dump", utf8Device, []byte("10 20 30"), "10 20 30"},
+		{"ASCII text is not a hex dump", utf8Device, []byte("41 42"), "41 42"},
+		{"words are not a hex dump without a charset", utf8Device, []byte("ab cd"), "ab cd"},
+	}
+	for _, tt := range tests {
+		if got := cs.Decode(tt.ip, tt.raw); got != tt.want {
+			t.Errorf("%s: Decode(%s, %q) = %q, want %q", tt.name, tt.ip, tt.raw, got, tt.want)
+		}
+	}
+
+	var none *Charsets
+	if got := none.Decode(cp1251, []byte("Порт")); got != "Порт" {
+		t.Errorf("Decode without charsets = %q, want %q", got, "Порт")
+	}
+}
+
+func TestDecodeHex(t *testing.T) {
+
+	tests := []struct {
+		in     string
+		want   []byte
+		wantOK bool
+	}{
+		{"D0 9F D1 80", []byte("Пр"), true},
+		{"d0:9f:d1:80", []byte("Пр"), true},
+		{"0xd09fd180", []byte("Пр"), true},
+		{"d0 9f 00 00", []byte{0xd0, 0x9f}, true},
+		{"41 42 43", nil, false},
+		{"10 20 30", nil, false},
+		{"d0 7f", nil, false},
+		{"d0 9", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := decodeHex(tt.in)
+		if ok != tt.wantOK || (ok && !bytes.Equal(got, tt.want)) {
+			t.Errorf("decodeHex(%q) = %x, %v, want %x, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if _, err := NewCharsets(CharsetConfig{Default: "ebcdic"}); err == nil {
+		t.Error("NewCharsets accepted an unknown charset")
+	}
+	if _, err := NewCharsets(CharsetConfig{Devices: map[string]string{"router": "cp1251"}}); err == nil {
+		t.Error("NewCharsets accepted a device without an address")
+	}
+}
@@ -0,0 +1,61 @@
+package linkevent
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Deduplicator drops retransmitted traps, those with a dedup key seen within the window
+type Deduplicator struct {
+	window     time.Duration
+	duplicates int64
+
+	mx        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewDeduplicator returns a Deduplicator, nil if the window is 0
+func NewDeduplicator(window time.Duration) *Deduplicator {
+	if window <= 0 {
+		return nil
+	}
+	return &Deduplicator{window: window, seen: map[string]time.Time{}, lastSweep: time.Now()}
+}
+
+// duplicate reports whether the key was seen within the window and remembers it otherwise.
+// An empty key is never a duplicate.
+func (d *Deduplicator) duplicate(key string) bool {
+	if d == nil || key == "" {
+		return false
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastSweep) > d.window {
+		for k, seen := range d.seen {
+			if now.Sub(seen) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if seen, ok := d.seen[key]; ok && now.Sub(seen) <= d.window {
+		atomic.AddInt64(&d.duplicates, 1)
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// Duplicates returns the number of duplicate traps dropped since start
+func (d *Deduplicator) Duplicates() int64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&d.duplicates)
+}
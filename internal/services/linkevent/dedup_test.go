@@ -0,0 +1,77 @@
+package linkevent
+
+import (
+	"net"
+	"snmpflapd/internal/repository/flapdb"
+	"testing"
+	"time"
+)
+
+func TestDeduplicator(t *testing.T) {
+
+	device := net.ParseIP("192.0.2.1")
+	trap := func(ifIndex, ifOperStatus int, timeTicks uint) string {
+		m := &flapdb.Model{IpAddress: device, IfIndex: ifIndex, IfAdminStatus: 1, IfOperStatus: ifOperStatus, TrapType: "linkDown", TimeTicks: timeTicks}
+		return m.DedupKey()
+	}
+
+	d := NewDeduplicator(time.Minute)
+
+	tests := []struct {
+		name string
+		key  string
+		// seenAgo moves the previous sighting of the key back in time
+		seenAgo time.Duration
+		want    bool
+	}{
+		{"first trap", trap(1, 2, 1000), 0, false},
+		{"retransmission", trap(1, 2, 1000), 0, true},
+		{"another interface", trap(2, 2, 1000), 0, false},
+		{"another status", trap(1, 1, 1000), 0, false},
+		{"another sysUpTime", trap(1, 2, 1001), 0, false},
+		{"retransmission within the window", trap(1, 2, 1000), time.Second * 59, true},
+		{"trap after the window", trap(1, 2, 1000), time.Second * 61, false},
+		{"no timeTicks", trap(3, 2, 0), 0, false},
+		{"no timeTicks again", trap(3, 2, 0), 0, false},
+	}
+	for _, tt := range tests {
+		if tt.seenAgo != 0 {
+			d.seen[tt.key] = time.Now().Add(-tt.seenAgo)
+		}
+		if got := d.duplicate(tt.key); got != tt.want {
+			t.Errorf("%s: duplicate(%q) = %v, want %v", tt.name, tt.key, got, tt.want)
+		}
+	}
+
+	if d.Duplicates() != 2 {
+		t.Errorf("%d duplicates, want 2", d.Duplicates())
+	}
+}
+
+func TestDeduplicatorSweep(t *testing.T) {
+
+	d := NewDeduplicator(time.Minute)
+	d.seen["old"] = time.Now().Add(-time.Minute * 2)
+	d.seen["recent"] = time.Now().Add(-time.Second * 30)
+	d.lastSweep = time.Now().Add(-time.Minute * 2)
+
+	d.duplicate("new")
+
+	if _, ok := d.seen["old"]; ok {
+		t.Error("expired key is kept")
+	}
+	if _, ok := d.seen["recent"]; !ok {
+		t.Error("key within the window is swept")
+	}
+}
+
+func TestDeduplicatorDisabled(t *testing.T) {
+
+	d := NewDeduplicator(0)
+	if d != nil {
+		t.Fatal("NewDeduplicator(0) is not nil")
+	}
+	if d.duplicate("key") || d.duplicate("key") || d.Duplicates() != 0 {
+		t.Error("disabled deduplicator drops traps")
+	}
+}
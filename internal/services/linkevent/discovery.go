@@ -0,0 +1,84 @@
+package linkevent
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// discoveryRetryBackoff is how long a device is not walked again after a failed walk
+const discoveryRetryBackoff = time.Minute * 5
+
+// discoveryTracker remembers when a device-wide SNMP walk was last done
+type discoveryTracker struct {
+	mx   sync.Mutex
+	seen map[string]time.Time
+}
+
+// devices returns every device discovered so far
+func (t *discoveryTracker) devices() []net.IP {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	ips := make([]net.IP, 0, len(t.seen))
+	for key := range t.seen {
+		ips = append(ips, net.ParseIP(key))
+	}
+	return ips
+}
+
+// forget makes the device to be discovered again
+func (t *discoveryTracker) forget(ip net.IP) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	delete(t.seen, ip.String())
+}
+
+// first reports whether the device is seen for the first time. The latest time it is seen is kept for expire.
+func (t *discoveryTracker) first(ip net.IP) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	key := ip.String()
+	_, ok := t.seen[key]
+	t.seen[key] = time.Now()
+	return !ok
+}
+
+// expire forgets devices not seen for maxAge and returns how many were forgotten
+func (t *discoveryTracker) expire(maxAge time.Duration) int {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	expired := 0
+	for key, seen := range t.seen {
+		if time.Since(seen) > maxAge {
+			delete(t.seen, key)
+			expired++
+		}
+	}
+	return expired
+}
+
+// due reports whether a device should be (re)discovered and marks it as discovered,
+// so concurrent events of the device don't walk it again. A failed walk should be reported with failed.
+func (t *discoveryTracker) due(ip net.IP, interval time.Duration) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	key := ip.String()
+	if last, ok := t.seen[key]; ok && time.Since(last) < interval {
+		return false
+	}
+	t.seen[key] = time.Now()
+	return true
+}
+
+// failed makes a device whose walk has failed due again after the backoff instead of the whole interval
+func (t *discoveryTracker) failed(ip net.IP, interval, backoff time.Duration) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.seen[ip.String()] = time.Now().Add(backoff - interval)
+}
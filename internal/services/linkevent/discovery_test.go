@@ -0,0 +1,49 @@
+package linkevent
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryTracker(t *testing.T) {
+
+	device, quiet := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	tracker := discoveryTracker{seen: map[string]time.Time{}}
+
+	if !tracker.first(device) || tracker.first(device) {
+		t.Error("first is true for the first sighting only")
+	}
+	tracker.first(quiet)
+	tracker.seen[quiet.String()] = time.Now().Add(-time.Hour * 2)
+
+	if expired := tracker.expire(time.Hour); expired != 1 {
+		t.Errorf("%d devices expired, want 1", expired)
+	}
+	if devices := tracker.devices(); len(devices) != 1 || !devices[0].Equal(device) {
+		t.Errorf("devices %v, want [%s]", devices, device)
+	}
+	if !tracker.first(quiet) {
+		t.Error("an expired device is not seen for the first time again")
+	}
+
+	tests := []struct {
+		name string
+		mark func()
+		want bool
+	}{
+		{"walked a moment ago", func() { tracker.seen[device.String()] = time.Now() }, false},
+		{"walked an interval ago", func() { tracker.seen[device.String()] = time.Now().Add(-time.Hour) }, true},
+		{"failed a moment ago", func() { tracker.failed(device, time.Hour, time.Minute) }, false},
+		{"failed a backoff ago", func() {
+			tracker.failed(device, time.Hour, time.Minute)
+			tracker.seen[device.String()] = tracker.seen[device.String()].Add(-time.Minute)
+		}, true},
+	}
+	for _, tt := range tests {
+		tt.mark()
+		if got := tracker.due(device, time.Hour); got != tt.want {
+			t.Errorf("%s: due = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"context"
 	"log"
 	"net"
+	"snmpflapd/internal/inventory"
 	"snmpflapd/internal/repository"
 	"snmpflapd/internal/repository/flapdb"
 	"strconv"
@@ -39,20 +40,54 @@ var (
 	snmpSema RequestSemaphore
 )
 
+// Config holds settings of link event handling
+type Config struct {
+	Community   string
+	Hostname    *HostnameResolver
+	Inventory   inventory.Provider
+	CachePolicy repository.CachePolicy
+	IfAlias     *IfAliasParser
+	Charsets    *Charsets
+	Dedup       *Deduplicator
+	// Instance names this collector in the events it stores
+	Instance string
+	// EnrichTimeout is how long missing data is fetched before the event is saved.
+	// An event enriched in time is saved at once, a slower one is saved as is and updated later.
+	EnrichTimeout time.Duration
+}
+
 type LinkEvent struct {
 	sid           string
 	ifIndex       int
 	ifAdminStatus int
 	ifOperStatus  int
+	trapType      string
+	instance      string
 	ifName        *string
 	ifAlias       *string
 	hostName      *string
+	hostSource    *string
+	trapHostName  *string
 	ipAddress     net.IP
 	time          time.Time
 	timeTicks     uint
+	lagIfIndex    *int
+	lagIfName     *string
+	parentIfIndex *int
+	parentSid     *string
+	site          *string
+	role          *string
+	owner         *string
+	tags          []string
+	noPolling     bool
+	ifDescription *string
+	peer          *string
+	attributes    map[string]string
 
 	repo      repository.Connector
+	cache     repository.Cache
 	community string
+	config    *Config
 }
 
 // FromSnmpPacket returns linkEvent from SnmpPacket and net.UDPAddr
@@ -64,6 +99,11 @@ func (le *LinkEvent) FromSnmpPacket(p *g.SnmpPacket, addr net.IP) {
 
 	le.ipAddress = addr
 
+	le.trapType = flapdb.TrapLinkDown
+	if getEventOID(p) == linkUP {
+		le.trapType = flapdb.TrapLinkUp
+	}
+
 	// Fill the linkEvent with variables from a packet
 	for _, variable := range p.Variables {
 
@@ -84,8 +124,8 @@ func (le *LinkEvent) FromSnmpPacket(p *g.SnmpPacket, addr net.IP) {
 
 		if strings.Contains(variable.Name, ifNameVarBindPrefixJunOS) {
 			ifNameBytes, ok := variable.Value.([]uint8)
-			ifName := string(ifNameBytes)
 			if ok {
+				ifName := le.config.Charsets.Decode(addr, ifNameBytes)
 				le.ifName = &ifName
 			} else {
 				log.Println(le, "empty ifNameVarBindPrefixJunOS")
@@ -93,6 +133,14 @@ func (le *LinkEvent) FromSnmpPacket(p *g.SnmpPacket, addr net.IP) {
 			continue
 		}
 
+		if variable.Name == sysNameOID {
+			if sysName, ok := variable.Value.([]uint8); ok {
+				trapHostName := le.config.Charsets.Decode(addr, sysName)
+				le.trapHostName = &trapHostName
+			}
+			continue
+		}
+
 		if strings.Contains(variable.Name, timeTicksReference) {
 
 			timeTicks, ok := variable.Value.(uint)
@@ -109,20 +157,47 @@ func (le *LinkEvent) FromSnmpPacket(p *g.SnmpPacket, addr net.IP) {
 }
 
 // LinkEventHandler handles linkUP/linkDOWN snmp traps
-func LinkEventHandler(ctx context.Context, repo repository.Connector, p *g.SnmpPacket, addr *net.UDPAddr, community string) {
-	event := LinkEvent{time: time.Now().Local(), repo: repo, community: community}
+func LinkEventHandler(ctx context.Context, repo repository.Connector, cache repository.Cache, p *g.SnmpPacket, addr *net.UDPAddr, cfg *Config) {
+	event := LinkEvent{time: time.Now().Local(), instance: cfg.Instance, repo: repo, cache: cache, community: cfg.Community, config: cfg}
 	event.sid = sid.Id() // This is for unique trap identification
 	event.FromSnmpPacket(p, addr.IP)
 
+	if m := event.model(); cfg.Dedup.duplicate(m.DedupKey()) {
+		log.Println(event.sid, "duplicate trap dropped:", m)
+		return
+	}
+
+	event.FillInventory()
+
 	// logVerbose(fmt.Sprintln(event.sid, "trap received:", event.String()))
 
-	if err := event.saveLinkEvent(); err != nil {
+	// The trap as received, saved if enrichment takes too long.
+	// Enrichment replaces fields of the event, it never modifies the values they point to.
+	received := event
+
+	enriched := make(chan struct{})
+	go func() {
+		event.FetchMissingData(ctx)
+		close(enriched)
+	}()
+
+	select {
+	case <-enriched:
+		if err := event.saveLinkEvent(); err != nil {
+			log.Println(event.sid, "unable to save link event", err)
+		}
+		return
+
+	case <-time.After(cfg.EnrichTimeout):
+	}
+
+	if err := received.saveLinkEvent(); err != nil {
 		log.Println(event.sid, "unable to save link event", err)
 		return
 	}
 
-	// Fetch missing data and update the linkEvent
-	event.FetchMissingData(ctx)
+	// Wait for missing data and update the linkEvent
+	<-enriched
 	if err := event.updateLinkEvent(); err != nil {
 		log.Println(event.sid, "unable to update link event:", err)
 	}
@@ -156,6 +231,13 @@ func (le *LinkEvent) FetchMissingData(ctx context.Context) {
 		le.FillHostName(ctx)
 	}
 
+	// ifName is set at this point only if it was sent in the trap
+	if le.ifName != nil {
+		le.ValidateTrapIfName(ctx)
+	}
+
+	le.PrewarmCaches(ctx)
+
 	if le.ifName == nil {
 		le.FillIfName(ctx)
 	}
@@ -163,30 +245,131 @@ func (le *LinkEvent) FetchMissingData(ctx context.Context) {
 	if le.ifAlias == nil {
 		le.FillIfAlias(ctx)
 	}
+
+	le.FillAttributes()
+
+	if le.peer == nil {
+		le.FillInventoryInterface()
+	}
+
+	if le.lagIfIndex == nil {
+		le.FillLag(ctx)
+	}
+
+	if le.parentIfIndex == nil {
+		le.FillStack(ctx)
+	}
 }
 
-// FillHostName tries to get a hostname from cache, then from the device via SNMP request
-func (le *LinkEvent) FillHostName(ctx context.Context) {
+// FillInventory applies the static inventory entry of the device, if any
+func (le *LinkEvent) FillInventory() {
 
-	// logVerbose(fmt.Sprintln(le.sid, "filling hostname"))
+	if le.config.Inventory == nil {
+		return
+	}
 
-	// 1. Try to get the value from cache
-	if le.getCachedHostname() {
-		// logVerbose(fmt.Sprintln(le.sid, "used cached hostName", *le.hostName))
+	entry, ok := le.config.Inventory.Lookup(le.ipAddress)
+	if !ok {
 		return
 	}
 
-	// 2. Get value from SNMP and put it to the cache
-	if hostName, err := getSNMPString(sysNameOID, le.ipAddress, le.community); err != nil {
-		log.Println(le.sid, "unable to get hostname via SNMP:", err)
+	if entry.Hostname != "" {
+		hostName, source := entry.Hostname, hostnameSourceInventory
+		le.hostName = &hostName
+		le.hostSource = &source
+	}
+
+	le.site = optionalString(entry.Site)
+	le.role = optionalString(entry.Role)
+	le.owner = optionalString(entry.Owner)
+	le.tags = entry.Tags
+	le.noPolling = entry.DisablePolling
+}
+
+// FillInventoryInterface applies the inventory record of the interface, if any
+func (le *LinkEvent) FillInventoryInterface() {
+
+	if le.config.Inventory == nil || le.ifName == nil {
 		return
+	}
 
-	} else {
-		le.hostName = hostName
-		// logVerbose(fmt.Sprintf("%s received hostname '%s' from %s via SNMP", le.sid, *le.hostName, le.ipAddress))
+	iface, ok := le.config.Inventory.LookupInterface(le.ipAddress, *le.ifName)
+	if !ok {
+		return
+	}
+
+	le.ifDescription = optionalString(iface.Description)
+	le.peer = optionalString(iface.Peer)
+}
+
+// optionalString returns nil for an empty string
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
 	}
+	return &s
+}
+
+// FillHostName walks the hostname resolution chain until a source supplies a hostname
+func (le *LinkEvent) FillHostName(ctx context.Context) {
 
-	if err := le.putCachedHostname(ctx); err != nil {
+	// logVerbose(fmt.Sprintln(le.sid, "filling hostname"))
+
+	le.resolveHostname(ctx, le.config.Hostname.sources)
+}
+
+// resolveHostname walks the sources until one supplies a hostname
+func (le *LinkEvent) resolveHostname(ctx context.Context, sources []string) {
+
+	resolver := le.config.Hostname
+
+	for _, source := range sources {
+		var hostName *string
+		var err error
+
+		switch source {
+		case HostnameSourceVarbind:
+			hostName = le.trapHostName
+
+		case HostnameSourceCache:
+			// Cached values are normalised already
+			if le.getCachedHostname(ctx) {
+				source := source
+				le.hostSource = &source
+				return
+			}
+
+		case HostnameSourceSNMP:
+			if le.noPolling {
+				continue
+			}
+			if hostName, err = le.snmpLookup(sysNameOID); err != nil {
+				log.Println(le.sid, "unable to get hostname via SNMP:", err)
+			}
+
+		case HostnameSourceDNS:
+			hostName, err = lookups.do(le.ipAddress.String()+" PTR", le.config.CachePolicy.NegativeTTL, func() (*string, error) {
+				return lookupDNS(ctx, le.ipAddress)
+			})
+			if err != nil {
+				log.Println(le.sid, "unable to get hostname via DNS:", err)
+			}
+
+		case HostnameSourceStatic:
+			hostName, _ = resolver.lookupStatic(le.ipAddress)
+		}
+
+		if hostName == nil || *hostName == "" {
+			continue
+		}
+
+		normalised := resolver.Normalise(*hostName)
+		source := source
+		le.hostName = &normalised
+		le.hostSource = &source
+
+		// Errors are logged by putCachedHostname, the hostname is used anyway
+		_ = le.putCachedHostname(ctx)
 		return
 	}
 
@@ -198,13 +381,17 @@ func (le *LinkEvent) FillIfName(ctx context.Context) {
 	// logVerbose(fmt.Sprintln(le.sid, "filling ifName"))
 
 	// 1. Try to get the value from cache
-	if le.getCachedIfName() {
+	if le.getCachedIfName(ctx) {
 		// logVerbose(fmt.Sprintf("%s used cached ifName %s", le.sid, *le.ifName))
 		return
 	}
 
 	// 2. Get value from SNMP and put it to the cache
-	if ifName, err := getSNMPString(ifNameOIDPrefix+strconv.Itoa(le.ifIndex), le.ipAddress, le.community); err != nil {
+	if le.noPolling {
+		return
+	}
+
+	if ifName, err := le.snmpLookup(ifNameOIDPrefix + strconv.Itoa(le.ifIndex)); err != nil {
 		log.Println(le.sid, "unable to get ifName vie SNMP:", err)
 		return
 
@@ -225,13 +412,17 @@ func (le *LinkEvent) FillIfAlias(ctx context.Context) {
 	// logVerbose(fmt.Sprintln(le.sid, "filling ifAlias"))
 
 	// 1. Try to get the value from cache
-	if le.getCachedIfAlias() {
+	if le.getCachedIfAlias(ctx) {
 		// logVerbose(fmt.Sprintf("%s used cached ifAlias '%s'", le.sid, *le.ifAlias))
 		return
 	}
 
 	// 2. Get value from SNMP and put it to the cache
-	ifAlias, err := getSNMPString(ifAliasOIDPrefix+strconv.Itoa(le.ifIndex), le.ipAddress, le.community)
+	if le.noPolling {
+		return
+	}
+
+	ifAlias, err := le.snmpLookup(ifAliasOIDPrefix + strconv.Itoa(le.ifIndex))
 	if err != nil {
 		log.Println(le.sid, "unable to get ifAlias via SNMP:", err)
 		return
@@ -252,32 +443,58 @@ func (le *LinkEvent) saveLinkEvent() error {
 		log.Println("SNMP Trap has no timeTicks", le)
 	}
 
-	model := &flapdb.Model{
-		IpAddress:     le.ipAddress,
-		HostName:      le.hostName,
-		IfIndex:       le.ifIndex,
-		IfName:        le.ifName,
-		IfAlias:       le.ifAlias,
-		IfAdminStatus: le.ifAdminStatus,
-		IfOperStatus:  le.ifOperStatus,
-		Time:          le.time,
-		Sid:           le.sid,
-		TimeTicks:     le.timeTicks,
-	}
-	if err := le.repo.SaveLinkEvent(model); err != nil {
+	if err := le.repo.SaveLinkEvent(le.model()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// model returns a flapdb.Model with all the fields of the linkEvent
+func (le *LinkEvent) model() *flapdb.Model {
+	return &flapdb.Model{
+		IpAddress:      le.ipAddress,
+		HostName:       le.hostName,
+		HostNameSource: le.hostSource,
+		IfIndex:        le.ifIndex,
+		IfName:         le.ifName,
+		IfAlias:        le.ifAlias,
+		IfAdminStatus:  le.ifAdminStatus,
+		IfOperStatus:   le.ifOperStatus,
+		TrapType:       le.trapType,
+		Instance:       le.instance,
+		Time:           le.time,
+		Sid:            le.sid,
+		TimeTicks:      le.timeTicks,
+		LagIfIndex:     le.lagIfIndex,
+		LagIfName:      le.lagIfName,
+		ParentIfIndex:  le.parentIfIndex,
+		ParentSid:      le.parentSid,
+		Site:           le.site,
+		Role:           le.role,
+		Owner:          le.owner,
+		Tags:           le.tags,
+		IfDescription:  le.ifDescription,
+		Peer:           le.peer,
+		Attributes:     le.attributes,
+	}
+}
+
 func (le *LinkEvent) updateLinkEvent() error {
 
 	model := &flapdb.Model{
-		HostName: le.hostName,
-		IfName:   le.ifName,
-		IfAlias:  le.ifAlias,
-		Sid:      le.sid,
+		HostName:       le.hostName,
+		HostNameSource: le.hostSource,
+		IfName:         le.ifName,
+		IfAlias:        le.ifAlias,
+		LagIfIndex:     le.lagIfIndex,
+		LagIfName:      le.lagIfName,
+		ParentIfIndex:  le.parentIfIndex,
+		ParentSid:      le.parentSid,
+		IfDescription:  le.ifDescription,
+		Peer:           le.peer,
+		Attributes:     le.attributes,
+		Sid:            le.sid,
 	}
 	if err := le.repo.UpdateLinkEvent(model); err != nil {
 		log.Println(le.sid, "unable to exec SQL query", err)
@@ -288,19 +505,26 @@ func (le *LinkEvent) updateLinkEvent() error {
 	return nil
 }
 
-func (le *LinkEvent) getCachedIfName() bool {
+func (le *LinkEvent) getCachedIfName(ctx context.Context) bool {
 
 	model := &flapdb.Model{
 		IpAddress: le.ipAddress,
 		IfIndex:   le.ifIndex,
 	}
-	cachedIfName, err := le.repo.GetCachedIfName(model)
+	cachedIfName, err := le.cache.GetCachedIfName(model)
 	if err != nil {
 		// logVerbose(fmt.Sprintln(le.sid, "no cached ifName"))
 		return false
 	}
 
-	le.ifName = cachedIfName
+	le.ifName = &cachedIfName.Value
+
+	if cachedIfName.Age > le.config.CachePolicy.IfNameTTL && !le.noPolling {
+		cache, ip, ifIndex := le.cache, le.ipAddress, le.ifIndex
+		le.refreshCached(ifNameOIDPrefix+strconv.Itoa(ifIndex), func(ctx context.Context, value *string) error {
+			return cache.PutCachedIfName(ctx, &flapdb.Model{IpAddress: ip, IfIndex: ifIndex, IfName: value})
+		})
+	}
 
 	return true
 }
@@ -310,8 +534,9 @@ func (le *LinkEvent) putCachedIfName(ctx context.Context) error {
 	model := &flapdb.Model{
 		IpAddress: le.ipAddress,
 		IfIndex:   le.ifIndex,
+		IfName:    le.ifName,
 	}
-	if err := le.repo.PutCachedIfName(ctx, model); err != nil {
+	if err := le.cache.PutCachedIfName(ctx, model); err != nil {
 		log.Println(le.sid, err)
 		return err
 	}
@@ -320,19 +545,27 @@ func (le *LinkEvent) putCachedIfName(ctx context.Context) error {
 	return nil
 }
 
-func (le *LinkEvent) getCachedIfAlias() bool {
+func (le *LinkEvent) getCachedIfAlias(ctx context.Context) bool {
 
 	model := &flapdb.Model{
 		IpAddress: le.ipAddress,
 		IfIndex:   le.ifIndex,
 	}
 
-	cachedIfAlias, err := le.repo.GetCachedIfAlias(model)
+	cachedIfAlias, err := le.cache.GetCachedIfAlias(model)
 	if err != nil {
 		// logVerbose(fmt.Sprintln(le.sid, "no cached ifAlias"))
 		return false
 	}
-	le.ifAlias = cachedIfAlias
+	le.ifAlias = &cachedIfAlias.Value
+
+	if cachedIfAlias.Age > le.config.CachePolicy.IfAliasTTL && !le.noPolling {
+		cache, ip, ifIndex := le.cache, le.ipAddress, le.ifIndex
+		le.refreshCached(ifAliasOIDPrefix+strconv.Itoa(ifIndex), func(ctx context.Context, value *string) error {
+			return cache.PutCachedIfAlias(ctx, &flapdb.Model{IpAddress: ip, IfIndex: ifIndex, IfAlias: value})
+		})
+	}
+
 	return true
 }
 
@@ -343,7 +576,7 @@ func (le *LinkEvent) putCachedIfAlias(ctx context.Context) error {
 		IfIndex:   le.ifIndex,
 		IfAlias:   le.ifAlias,
 	}
-	if err := le.repo.PutCachedIfAlias(ctx, model); err != nil {
+	if err := le.cache.PutCachedIfAlias(ctx, model); err != nil {
 		log.Println(le.sid, err)
 		return err
 	}
@@ -354,22 +587,69 @@ func (le *LinkEvent) putCachedIfAlias(ctx context.Context) error {
 
 }
 
-func (le *LinkEvent) getCachedHostname() bool {
+func (le *LinkEvent) getCachedHostname(ctx context.Context) bool {
 	model := &flapdb.Model{
 		IpAddress: le.ipAddress,
 	}
 
-	cachedHostname, err := le.repo.GetCachedHostname(model)
+	cachedHostname, err := le.cache.GetCachedHostname(model)
 	if err != nil {
 		// logVerbose(fmt.Sprintln(le.sid, "no cached hostname"))
 		return false
 	}
 
-	le.hostName = cachedHostname
+	le.hostName = &cachedHostname.Value
+
+	// Refresh the stale hostname with the rest of the chain. The handler goes on filling le,
+	// so the refresh gets a copy of the fields the chain reads.
+	if cachedHostname.Age > le.config.CachePolicy.HostnameTTL {
+		refresh := &LinkEvent{
+			sid:          le.sid,
+			ipAddress:    le.ipAddress,
+			trapHostName: le.trapHostName,
+			noPolling:    le.noPolling,
+			cache:        le.cache,
+			community:    le.community,
+			config:       le.config,
+		}
+
+		var sources []string
+		for _, source := range le.config.Hostname.sources {
+			if source != HostnameSourceCache {
+				sources = append(sources, source)
+			}
+		}
+
+		lookups.refresh(le.ipAddress.String()+" hostname", func(ctx context.Context) {
+			refresh.resolveHostname(ctx, sources)
+		})
+	}
 
 	return true
 }
 
+// snmpLookup gets a string via SNMP unless the same lookup has failed recently
+func (le *LinkEvent) snmpLookup(oid string) (*string, error) {
+	return lookups.do(le.ipAddress.String()+" "+oid, le.config.CachePolicy.NegativeTTL, func() (*string, error) {
+		return getSNMPString(oid, le.ipAddress, le.community, le.config.Charsets)
+	})
+}
+
+// refreshCached gets a stale cached value via SNMP in the background and stores it with put
+func (le *LinkEvent) refreshCached(oid string, put func(context.Context, *string) error) {
+	lookup := &LinkEvent{sid: le.sid, ipAddress: le.ipAddress, community: le.community, config: le.config}
+	lookups.refresh(le.ipAddress.String()+" "+oid, func(ctx context.Context) {
+		value, err := lookup.snmpLookup(oid)
+		if err != nil {
+			log.Println(lookup.sid, "unable to refresh cached value via SNMP:", err)
+			return
+		}
+		if err := put(ctx, value); err != nil {
+			log.Println(lookup.sid, err)
+		}
+	})
+}
+
 func (le *LinkEvent) putCachedHostname(ctx context.Context) error {
 
 	model := &flapdb.Model{
@@ -377,7 +657,7 @@ func (le *LinkEvent) putCachedHostname(ctx context.Context) error {
 		HostName:  le.hostName,
 	}
 
-	if err := le.repo.PutCachedHostname(ctx, model); err != nil {
+	if err := le.cache.PutCachedHostname(ctx, model); err != nil {
 		log.Println(le.sid, err)
 		return err
 	}
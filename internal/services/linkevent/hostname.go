@@ -0,0 +1,147 @@
+// This file is responsible for hostname resolution.
+// A hostname is taken from the first source of the configured chain that supplies one,
+// then it is normalised according to the configured rules.
+
+package linkevent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	HostnameSourceVarbind = "varbind"
+	HostnameSourceCache   = "cache"
+	HostnameSourceSNMP    = "snmp"
+	HostnameSourceDNS     = "dns"
+	HostnameSourceStatic  = "static"
+
+	// hostnameSourceInventory marks hostnames overridden by the inventory
+	hostnameSourceInventory = "inventory"
+
+	dnsLookupTimeout = time.Second * 3
+)
+
+// DefaultHostnameSources is the hostname resolution chain used when none is configured
+var DefaultHostnameSources = []string{
+	HostnameSourceVarbind,
+	HostnameSourceCache,
+	HostnameSourceSNMP,
+	HostnameSourceDNS,
+	HostnameSourceStatic,
+}
+
+// HostnameConfig describes how hostnames are resolved and normalised
+type HostnameConfig struct {
+	Sources     []string
+	StripDomain bool
+	Lowercase   bool
+	Rewrites    []HostnameRewrite
+	Static      map[string]string
+}
+
+// HostnameRewrite replaces matches of Pattern in a hostname with Replace
+type HostnameRewrite struct {
+	Pattern string
+	Replace string
+}
+
+// HostnameResolver is a compiled HostnameConfig
+type HostnameResolver struct {
+	sources     []string
+	stripDomain bool
+	lowercase   bool
+	rewrites    []hostnameRewrite
+	static      map[string]string
+}
+
+type hostnameRewrite struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// NewHostnameResolver validates the config and compiles rewrite rules
+func NewHostnameResolver(cfg HostnameConfig) (*HostnameResolver, error) {
+
+	r := &HostnameResolver{
+		sources:     cfg.Sources,
+		stripDomain: cfg.StripDomain,
+		lowercase:   cfg.Lowercase,
+		static:      cfg.Static,
+	}
+
+	if len(r.sources) == 0 {
+		r.sources = DefaultHostnameSources
+	}
+
+	for _, source := range r.sources {
+		switch source {
+		case HostnameSourceVarbind, HostnameSourceCache, HostnameSourceSNMP, HostnameSourceDNS, HostnameSourceStatic:
+		default:
+			return nil, fmt.Errorf("unknown hostname source %q", source)
+		}
+	}
+
+	for _, rewrite := range cfg.Rewrites {
+		re, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("wrong hostname rewrite %q: %w", rewrite.Pattern, err)
+		}
+		r.rewrites = append(r.rewrites, hostnameRewrite{re: re, replace: rewrite.Replace})
+	}
+
+	return r, nil
+}
+
+// Normalise applies the normalisation rules to a hostname
+func (r *HostnameResolver) Normalise(hostName string) string {
+
+	hostName = strings.TrimSpace(hostName)
+
+	if r.stripDomain {
+		if i := strings.Index(hostName, "."); i > 0 {
+			hostName = hostName[:i]
+		}
+	}
+
+	if r.lowercase {
+		hostName = strings.ToLower(hostName)
+	}
+
+	for _, rewrite := range r.rewrites {
+		hostName = rewrite.re.ReplaceAllString(hostName, rewrite.replace)
+	}
+
+	return hostName
+}
+
+// lookupStatic returns a statically configured hostname
+func (r *HostnameResolver) lookupStatic(ip net.IP) (*string, error) {
+	if hostName, ok := r.static[ip.String()]; ok {
+		return &hostName, nil
+	}
+	return nil, errors.New("no static hostname")
+}
+
+// lookupDNS returns a hostname from a reverse DNS PTR record
+func lookupDNS(ctx context.Context, ip net.IP) (*string, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no PTR record")
+	}
+
+	hostName := strings.TrimSuffix(names[0], ".")
+	return &hostName, nil
+}
@@ -0,0 +1,130 @@
+// This file is responsible for structured parsing of ifAlias.
+// Interface descriptions following a convention like "CID:12345 | CUST:Acme | ROLE:uplink"
+// are matched with the configured extractors and their named captures are stored as event attributes.
+
+package linkevent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholder is a {name} of an ifAlias template
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// IfAliasExtractor is either a regular expression with named captures, e.g. `CID:(?P<circuit>\d+)`,
+// or a template with {name} placeholders, e.g. "CID:{circuit} | CUST:{customer}"
+type IfAliasExtractor struct {
+	Pattern  string
+	Template string
+}
+
+// IfAliasParser is a compiled list of IfAliasExtractor
+type IfAliasParser struct {
+	extractors []*regexp.Regexp
+}
+
+// NewIfAliasParser validates and compiles the extractors
+func NewIfAliasParser(extractors []IfAliasExtractor) (*IfAliasParser, error) {
+
+	p := &IfAliasParser{}
+
+	for _, extractor := range extractors {
+		pattern := extractor.Pattern
+		if extractor.Template != "" {
+			pattern = templatePattern(extractor.Template)
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("wrong ifAlias extractor %q: %w", pattern, err)
+		}
+
+		named := false
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				named = true
+			}
+		}
+		if !named {
+			return nil, fmt.Errorf("ifAlias extractor %q has no named captures", pattern)
+		}
+
+		p.extractors = append(p.extractors, re)
+	}
+
+	return p, nil
+}
+
+// templatePattern turns a template into a regular expression matching the whole ifAlias.
+// Literal text is matched as is, except for spaces that match any amount of whitespace.
+func templatePattern(template string) string {
+
+	var pattern strings.Builder
+	pattern.WriteString(`^\s*`)
+
+	literal := func(s string) {
+		for i, word := range strings.Fields(s) {
+			if i > 0 || strings.TrimLeft(s, " \t") != s {
+				pattern.WriteString(`\s*`)
+			}
+			pattern.WriteString(regexp.QuoteMeta(word))
+		}
+		if strings.TrimRight(s, " \t") != s {
+			pattern.WriteString(`\s*`)
+		}
+	}
+
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(template, -1) {
+		literal(template[last:m[0]])
+		pattern.WriteString(`(?P<` + template[m[2]:m[3]] + `>.*?)`)
+		last = m[1]
+	}
+	literal(template[last:])
+
+	pattern.WriteString(`\s*$`)
+	return pattern.String()
+}
+
+// Parse returns named captures of every matching extractor. The first extractor supplying a name wins.
+func (p *IfAliasParser) Parse(ifAlias string) map[string]string {
+
+	if p == nil || len(p.extractors) == 0 {
+		return nil
+	}
+
+	attributes := map[string]string{}
+	for _, re := range p.extractors {
+		match := re.FindStringSubmatch(ifAlias)
+		if match == nil {
+			continue
+		}
+
+		for i, name := range re.SubexpNames() {
+			value := strings.TrimSpace(match[i])
+			if name == "" || value == "" {
+				continue
+			}
+			if _, ok := attributes[name]; !ok {
+				attributes[name] = value
+			}
+		}
+	}
+
+	if len(attributes) == 0 {
+		return nil
+	}
+	return attributes
+}
+
+// FillAttributes parses ifAlias into structured attributes
+func (le *LinkEvent) FillAttributes() {
+
+	if le.ifAlias == nil {
+		return
+	}
+
+	le.attributes = le.config.IfAlias.Parse(*le.ifAlias)
+}
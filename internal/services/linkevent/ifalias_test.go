@@ -0,0 +1,84 @@
+package linkevent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplatePattern(t *testing.T) {
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"CID:{circuit}", `^\s*CID:(?P<circuit>.*?)\s*$`},
+		{"CID:{circuit} | CUST:{customer}", `^\s*CID:(?P<circuit>.*?)\s*\|\s*CUST:(?P<customer>.*?)\s*$`},
+		{"{site}-{rack}", `^\s*(?P<site>.*?)-(?P<rack>.*?)\s*$`},
+		{"to {peer} (port {port})", `^\s*to\s*(?P<peer>.*?)\s*\(port\s*(?P<port>.*?)\)\s*$`},
+	}
+	for _, tt := range tests {
+		if got := templatePattern(tt.template); got != tt.want {
+			t.Errorf("templatePattern(%q) = %s, want %s", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestIfAliasParse(t *testing.T) {
+
+	p, err := NewIfAliasParser([]IfAliasExtractor{
+		{Template: "CID:{circuit} | CUST:{customer} | ROLE:{role}"},
+		{Pattern: `CID:(?P<circuit>\d+)`},
+		{Pattern: `(?i)role=(?P<role>\w+)`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ifAlias string
+		want    map[string]string
+	}{
+		{"template", "CID:12345 | CUST:Acme Corp | ROLE:uplink",
+			map[string]string{"circuit": "12345", "customer": "Acme Corp", "role": "uplink"}},
+		{"template with irregular spaces", "  CID:12345|CUST: Acme  |  ROLE:uplink ",
+			map[string]string{"circuit": "12345", "customer": "Acme", "role": "uplink"}},
+		{"first extractor wins", "CID:777 | CUST:Acme | ROLE:core role=edge",
+			map[string]string{"circuit": "777", "customer": "Acme", "role": "core role=edge"}},
+		{"patterns only", "to core CID:42 Role=backup", map[string]string{"circuit": "42", "role": "backup"}},
+		{"empty captures are skipped", "CID: | CUST:Acme | ROLE:", map[string]string{"customer": "Acme"}},
+		{"no match", "uplink to core", nil},
+	}
+	for _, tt := range tests {
+		if got := p.Parse(tt.ifAlias); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse(%q) = %v, want %v", tt.name, tt.ifAlias, got, tt.want)
+		}
+	}
+
+	var none *IfAliasParser
+	if got := none.Parse("CID:12345"); got != nil {
+		t.Errorf("Parse without extractors = %v, want nil", got)
+	}
+}
+
+func TestNewIfAliasParser(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		extractor IfAliasExtractor
+		wantErr   bool
+	}{
+		{"template", IfAliasExtractor{Template: "CID:{circuit}"}, false},
+		{"pattern", IfAliasExtractor{Pattern: `CID:(?P<circuit>\d+)`}, false},
+		{"template metacharacters are literal", IfAliasExtractor{Template: "[{site}] (*{rack}*)"}, false},
+		{"template without placeholders", IfAliasExtractor{Template: "uplink"}, true},
+		{"pattern without named captures", IfAliasExtractor{Pattern: `CID:(\d+)`}, true},
+		{"wrong pattern", IfAliasExtractor{Pattern: `CID:(?P<circuit>\d+`}, true},
+	}
+	for _, tt := range tests {
+		_, err := NewIfAliasParser([]IfAliasExtractor{tt.extractor})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// This file is responsible for ifStackTable discovery.
+// Sub-interfaces, VLAN interfaces and tunnels flap together with their physical port,
+// so events of a child interface are linked to the event of its parent (lower layer) interface.
+
+package linkevent
+
+import (
+	"context"
+	"log"
+	"net"
+	"snmpflapd/internal/repository/flapdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ifStackStatusOID         = ".1.3.6.1.2.1.31.1.2.1.3"
+	ifStackStatusActive      = 1
+	ifStackDiscoveryInterval = time.Hour * 6
+	stackCorrelationWindow   = time.Second * 30
+)
+
+var (
+	ifStackDiscovery = discoveryTracker{seen: map[string]time.Time{}}
+)
+
+// FillStack links the event with events of its parent and child interfaces
+func (le *LinkEvent) FillStack(ctx context.Context) {
+
+	if !le.noPolling && ifStackDiscovery.due(le.ipAddress, ifStackDiscoveryInterval) {
+		if err := le.discoverIfStack(ctx); err != nil {
+			log.Println(le.sid, "unable to discover ifStackTable via SNMP:", err)
+			ifStackDiscovery.failed(le.ipAddress, ifStackDiscoveryInterval, discoveryRetryBackoff)
+		}
+	}
+
+	stack, err := le.repo.GetIfStack(&flapdb.Model{IpAddress: le.ipAddress})
+	if err != nil {
+		log.Println(le.sid, "unable to get ifStackTable:", err)
+		return
+	}
+
+	parents := stackParents(stack)
+
+	// The event is a consequence of the parent's event
+	if parentIfIndex, ok := parents[le.ifIndex]; ok {
+		le.parentIfIndex = &parentIfIndex
+
+		parentEvent := le.model()
+		parentEvent.IfIndex = parentIfIndex
+		if parentSid, err := le.repo.GetStackParentEvent(parentEvent, stackCorrelationWindow); err == nil {
+			le.parentSid = parentSid
+		}
+	}
+
+	// Events of the children already stored are consequences of this one
+	var children []int
+	for child, parent := range parents {
+		if parent == le.ifIndex {
+			children = append(children, child)
+		}
+	}
+	if len(children) > 0 {
+		if err := le.repo.MarkStackChildEvents(ctx, le.model(), children, stackCorrelationWindow); err != nil {
+			log.Println(le.sid, "unable to mark child events:", err)
+		}
+	}
+}
+
+// discoverIfStack walks the device and stores its ifStackTable
+func (le *LinkEvent) discoverIfStack(ctx context.Context) error {
+
+	stack, err := walkIfStack(le.ipAddress, le.community)
+	if err != nil {
+		return err
+	}
+
+	ifTypes, err := walkSNMPInts(ifTypeOID, le.ipAddress, le.community)
+	if err != nil {
+		return err
+	}
+
+	return le.repo.PutIfStack(ctx, le.ipAddress, withoutLagBundles(stack, ifTypes))
+}
+
+// withoutLagBundles drops the rows of LAG bundles, ifTypes are keyed by ifIndex.
+// A bundle runs on top of its members but it's the member's event that is caused by the bundle's,
+// and a bundle with one member would be taken for a child of it. LAG membership is handled by FillLag.
+func withoutLagBundles(stack []flapdb.IfStackEntry, ifTypes map[string]int) []flapdb.IfStackEntry {
+
+	entries := make([]flapdb.IfStackEntry, 0, len(stack))
+	for _, entry := range stack {
+		if ifTypes[strconv.Itoa(entry.HigherIfIndex)] == ifTypeIEEE8023adLag {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// stackParents maps child ifIndexes to their parent ifIndex.
+// Interfaces stacked over several lower layers have no single parent and are skipped.
+func stackParents(stack []flapdb.IfStackEntry) map[int]int {
+
+	lowers := map[int][]int{}
+	for _, entry := range stack {
+		lowers[entry.HigherIfIndex] = append(lowers[entry.HigherIfIndex], entry.LowerIfIndex)
+	}
+
+	parents := make(map[int]int, len(lowers))
+	for higher, lower := range lowers {
+		if len(lower) == 1 {
+			parents[higher] = lower[0]
+		}
+	}
+	return parents
+}
+
+// walkIfStack returns active ifStackTable rows, skipping the top and bottom of the stack
+func walkIfStack(ip net.IP, community string) ([]flapdb.IfStackEntry, error) {
+
+	statuses, err := walkSNMPInts(ifStackStatusOID, ip, community)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []flapdb.IfStackEntry
+	for suffix, status := range statuses {
+		if status != ifStackStatusActive {
+			continue
+		}
+
+		indexes := strings.Split(suffix, ".")
+		if len(indexes) != 2 {
+			continue
+		}
+		higher, errHigher := strconv.Atoi(indexes[0])
+		lower, errLower := strconv.Atoi(indexes[1])
+		if errHigher != nil || errLower != nil || higher == 0 || lower == 0 {
+			continue
+		}
+		entries = append(entries, flapdb.IfStackEntry{HigherIfIndex: higher, LowerIfIndex: lower})
+	}
+
+	return entries, nil
+}
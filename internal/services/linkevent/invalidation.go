@@ -0,0 +1,59 @@
+// This file is responsible for cache invalidation.
+// When an ifName sent by the device disagrees with the cached one for the same ifIndex,
+// the device has renumbered its interfaces and nothing cached for it can be trusted.
+
+package linkevent
+
+import (
+	"context"
+	"log"
+	"net"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+)
+
+// ValidateTrapIfName compares the ifName sent in the trap with the cached one
+// and invalidates the device caches on mismatch
+func (le *LinkEvent) ValidateTrapIfName(ctx context.Context) {
+
+	model := &flapdb.Model{
+		IpAddress: le.ipAddress,
+		IfIndex:   le.ifIndex,
+	}
+	cached, err := le.cache.GetCachedIfName(model)
+	if err != nil || cached.Value == *le.ifName {
+		return
+	}
+
+	log.Printf("%s ifName mismatch on %s ifIndex %d: trap %q, cached %q. The device has renumbered, invalidating its caches",
+		le.sid, le.ipAddress, le.ifIndex, *le.ifName, cached.Value)
+
+	invalidateDevice(ctx, le.cache, le.ipAddress)
+
+	// Walk ifXTable again with this event
+	ifTableDiscovery.forget(le.ipAddress)
+}
+
+// invalidateDevice deletes cached ifNames and ifAliases of the device and schedules its rediscovery
+func invalidateDevice(ctx context.Context, cache repository.Cache, ip net.IP) {
+
+	if err := cache.InvalidateDevice(ctx, ip); err != nil {
+		log.Println(ip, "unable to invalidate cache:", err)
+	}
+
+	lagDiscovery.forget(ip)
+	ifStackDiscovery.forget(ip)
+}
+
+// countChanged returns how many cached values differ from the live ones
+func countChanged(get func(*flapdb.Model) (*flapdb.CachedValue, error), ip net.IP, live map[int]string) int {
+
+	changed := 0
+	for ifIndex, value := range live {
+		cached, err := get(&flapdb.Model{IpAddress: ip, IfIndex: ifIndex})
+		if err == nil && cached.Value != value {
+			changed++
+		}
+	}
+	return changed
+}
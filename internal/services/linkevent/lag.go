@@ -0,0 +1,133 @@
+// This file is responsible for LAG (port-channel) membership discovery.
+// Membership is read from IEEE8023-LAG-MIB, falling back to ifStackTable
+// for devices that don't implement it, and is stored per device using the *Connector.
+
+package linkevent
+
+import (
+	"context"
+	"log"
+	"net"
+	"snmpflapd/internal/repository/flapdb"
+	"strconv"
+	"time"
+)
+
+const (
+	dot3adAggPortAttachedAggIDOID = ".1.2.840.10006.300.43.1.2.1.1.13"
+	ifTypeOID                     = ".1.3.6.1.2.1.2.2.1.3"
+	ifTypeIEEE8023adLag           = 161
+	lagDiscoveryInterval          = time.Hour * 6
+)
+
+var (
+	lagDiscovery = discoveryTracker{seen: map[string]time.Time{}}
+)
+
+// FillLag finds the LAG bundle the interface is a member of
+func (le *LinkEvent) FillLag(ctx context.Context) {
+
+	if !le.noPolling && lagDiscovery.due(le.ipAddress, lagDiscoveryInterval) {
+		if err := le.discoverLag(ctx); err != nil {
+			log.Println(le.sid, "unable to discover LAG membership via SNMP:", err)
+			lagDiscovery.failed(le.ipAddress, lagDiscoveryInterval, discoveryRetryBackoff)
+		}
+	}
+
+	model := &flapdb.Model{
+		IpAddress: le.ipAddress,
+		IfIndex:   le.ifIndex,
+	}
+	member, err := le.repo.GetLagMember(model)
+	if err != nil {
+		// Not a LAG member
+		return
+	}
+
+	le.lagIfIndex = &member.LagIfIndex
+	le.lagIfName = le.lookupIfName(ctx, member.LagIfIndex)
+}
+
+// discoverLag walks the device and stores its LAG membership
+func (le *LinkEvent) discoverLag(ctx context.Context) error {
+
+	members, err := discoverLagMembers(le.ipAddress, le.community)
+	if err != nil {
+		return err
+	}
+
+	return le.repo.PutLagMembers(ctx, le.ipAddress, members)
+}
+
+// lookupIfName returns an ifName of any interface of the device, from cache or via SNMP
+func (le *LinkEvent) lookupIfName(ctx context.Context, ifIndex int) *string {
+
+	model := &flapdb.Model{
+		IpAddress: le.ipAddress,
+		IfIndex:   ifIndex,
+	}
+	if cachedIfName, err := le.cache.GetCachedIfName(model); err == nil {
+		return &cachedIfName.Value
+	}
+
+	if le.noPolling {
+		return nil
+	}
+
+	ifName, err := le.snmpLookup(ifNameOIDPrefix + strconv.Itoa(ifIndex))
+	if err != nil {
+		log.Println(le.sid, "unable to get ifName via SNMP:", err)
+		return nil
+	}
+
+	model.IfName = ifName
+	if err := le.cache.PutCachedIfName(ctx, model); err != nil {
+		log.Println(le.sid, err)
+	}
+
+	return ifName
+}
+
+// discoverLagMembers returns LAG membership of a device.
+// IEEE8023-LAG-MIB is preferred; ifStackTable combined with ifType is used otherwise.
+func discoverLagMembers(ip net.IP, community string) ([]flapdb.LagMember, error) {
+
+	attached, err := walkSNMPInts(dot3adAggPortAttachedAggIDOID, ip, community)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []flapdb.LagMember
+
+	if len(attached) > 0 {
+		for suffix, lagIfIndex := range attached {
+			ifIndex, err := strconv.Atoi(suffix)
+			if err != nil || lagIfIndex == 0 || lagIfIndex == ifIndex {
+				continue
+			}
+			members = append(members, flapdb.LagMember{IfIndex: ifIndex, LagIfIndex: lagIfIndex})
+		}
+		return members, nil
+	}
+
+	stack, err := walkIfStack(ip, community)
+	if err != nil {
+		return nil, err
+	}
+	if len(stack) == 0 {
+		return nil, nil
+	}
+
+	ifTypes, err := walkSNMPInts(ifTypeOID, ip, community)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range stack {
+		if ifTypes[strconv.Itoa(entry.HigherIfIndex)] == ifTypeIEEE8023adLag {
+			members = append(members, flapdb.LagMember{IfIndex: entry.LowerIfIndex, LagIfIndex: entry.HigherIfIndex})
+		}
+	}
+
+	return members, nil
+}
@@ -0,0 +1,139 @@
+// This file is responsible for pre-warming ifName and ifAlias caches.
+// ifXTable is walked with GETBULK the first time a device is seen and periodically after that,
+// so the first flap of a port doesn't pay for a live SNMP Get.
+
+package linkevent
+
+import (
+	"context"
+	"log"
+	"net"
+	"snmpflapd/internal/repository"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// prewarmDeviceTTL is how long a device is walked periodically after its latest trap
+	prewarmDeviceTTL = time.Hour * 24 * 7
+	// prewarmConcurrency bounds the devices walked at once, so unreachable ones don't hold up the rest
+	prewarmConcurrency = 4
+)
+
+var (
+	ifTableDiscovery = discoveryTracker{seen: map[string]time.Time{}}
+)
+
+// PrewarmCaches walks ifXTable of the device if it has not been seen yet
+func (le *LinkEvent) PrewarmCaches(ctx context.Context) {
+
+	if le.noPolling || !ifTableDiscovery.first(le.ipAddress) {
+		return
+	}
+
+	if err := prewarmDevice(ctx, le.cache, le.ipAddress, le.config, false); err != nil {
+		log.Println(le.sid, "unable to walk ifXTable:", err)
+	}
+}
+
+// RunCachePrewarm periodically walks ifXTable of every device that has sent a trap to the instance
+// within prewarmDeviceTTL, re-validating cached values against the live devices
+func RunCachePrewarm(ctx context.Context, cache repository.Cache, cfg *Config, period time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("closed due context")
+			return
+		case <-time.After(period):
+			if expired := ifTableDiscovery.expire(prewarmDeviceTTL); expired > 0 {
+				log.Printf("%d devices without traps for %s are no longer walked", expired, prewarmDeviceTTL)
+			}
+			prewarmDevices(ctx, cache, ifTableDiscovery.devices(), cfg)
+		}
+	}
+}
+
+// prewarmDevices walks the devices, prewarmConcurrency at once
+func prewarmDevices(ctx context.Context, cache repository.Cache, ips []net.IP, cfg *Config) {
+
+	queue := make(chan net.IP)
+	var wg sync.WaitGroup
+	for i := 0; i < prewarmConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range queue {
+				if err := prewarmDevice(ctx, cache, ip, cfg, true); err != nil {
+					log.Println(ip, "unable to walk ifXTable:", err)
+				}
+			}
+		}()
+	}
+
+	for _, ip := range ips {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- ip
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// prewarmDevice walks ifName and ifAlias columns of ifXTable and replaces cached values of the device.
+// With revalidate, cached values are compared with the walked ones first.
+func prewarmDevice(ctx context.Context, cache repository.Cache, ip net.IP, cfg *Config, revalidate bool) error {
+
+	ifNames, err := walkIfXTableColumn(ifNameOIDPrefix, ip, cfg)
+	if err != nil {
+		return err
+	}
+
+	ifAliases, err := walkIfXTableColumn(ifAliasOIDPrefix, ip, cfg)
+	if err != nil {
+		return err
+	}
+
+	if revalidate {
+		if changed := countChanged(cache.GetCachedIfAlias, ip, ifAliases); changed > 0 {
+			log.Printf("%s %d cached ifAliases changed on the device", ip, changed)
+		}
+
+		// Changed ifNames mean the device has renumbered its interfaces
+		if changed := countChanged(cache.GetCachedIfName, ip, ifNames); changed > 0 {
+			log.Printf("%s %d cached ifNames don't match the device, the device has renumbered", ip, changed)
+			invalidateDevice(ctx, cache, ip)
+		}
+	}
+
+	if err := cache.PutCachedIfNames(ctx, ip, ifNames); err != nil {
+		return err
+	}
+	if err := cache.PutCachedIfAliases(ctx, ip, ifAliases); err != nil {
+		return err
+	}
+
+	log.Printf("%s ifXTable walked: %d ifNames, %d ifAliases", ip, len(ifNames), len(ifAliases))
+	return nil
+}
+
+// walkIfXTableColumn returns values of an ifXTable column keyed by ifIndex
+func walkIfXTableColumn(oidPrefix string, ip net.IP, cfg *Config) (map[int]string, error) {
+
+	values, err := walkSNMPStrings(strings.TrimSuffix(oidPrefix, "."), ip, cfg.Community, cfg.Charsets)
+	if err != nil {
+		return nil, err
+	}
+
+	column := make(map[int]string, len(values))
+	for suffix, value := range values {
+		ifIndex, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		column[ifIndex] = value
+	}
+	return column, nil
+}
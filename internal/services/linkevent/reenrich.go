@@ -0,0 +1,162 @@
+// This file is responsible for re-enrichment of incomplete link events.
+// An event keeps NULL hostname, ifName or ifAlias if the device didn't answer at the time of the flap,
+// so such events are retried in the background with exponential back-off until they get too old.
+
+package linkevent
+
+import (
+	"context"
+	"log"
+	"snmpflapd/internal/repository"
+	"snmpflapd/internal/repository/flapdb"
+	"snmpflapd/internal/services/leader"
+	"time"
+)
+
+const (
+	reEnrichBatchSize  = 500
+	reEnrichMaxBackOff = time.Hour
+)
+
+// reEnrichAttempt is the retry state of an incomplete event
+type reEnrichAttempt struct {
+	attempts int
+	next     time.Time
+	// time of the event, it is given up once it gets too old
+	time time.Time
+}
+
+// RunReEnrichment periodically retries enrichment of recent events with missing hostname, ifName or ifAlias.
+// Events older than maxAge are given up. Only the leader retries, instances share the stored events.
+// A batch of events is taken every period, continuing after the previous batch, so events that can't be
+// completed never keep the newer ones from being retried.
+func RunReEnrichment(ctx context.Context, repo repository.Connector, cache repository.Cache, cfg *Config, elector *leader.Elector, period, maxAge time.Duration) {
+
+	attempts := map[string]*reEnrichAttempt{}
+
+	// cursor is the last event of the previous batch, nil to start from the oldest events again
+	var cursor *flapdb.Model
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("closed due context")
+			return
+		case <-time.After(period):
+			if !elector.IsLeader() {
+				continue
+			}
+
+			since := time.Now().Add(-maxAge)
+			from, afterSid := since, ""
+			if cursor != nil && cursor.Time.After(since) {
+				from, afterSid = cursor.Time, cursor.Sid
+			}
+
+			events, err := repo.GetIncompleteEvents(ctx, from, afterSid, reEnrichBatchSize)
+			if err != nil {
+				log.Println("unable to get incomplete events:", err)
+				continue
+			}
+
+			cursor = nil
+			if len(events) == reEnrichBatchSize {
+				cursor = events[len(events)-1]
+			}
+
+			for _, m := range events {
+				attempt, ok := attempts[m.Sid]
+				if !ok {
+					attempt = &reEnrichAttempt{time: m.Time}
+					attempts[m.Sid] = attempt
+				}
+
+				if time.Now().Before(attempt.next) {
+					continue
+				}
+
+				complete, retry := reEnrich(ctx, repo, cache, cfg, m)
+				switch {
+				case complete:
+					delete(attempts, m.Sid)
+				case !retry:
+					attempt.next = m.Time.Add(maxAge)
+				default:
+					attempt.attempts++
+					attempt.next = time.Now().Add(reEnrichBackOff(period, attempt.attempts))
+				}
+			}
+
+			// Events that got too old are forgotten
+			for sid, attempt := range attempts {
+				if attempt.time.Before(since) {
+					delete(attempts, sid)
+				}
+			}
+		}
+	}
+}
+
+// reEnrichBackOff doubles the period with every failed attempt
+func reEnrichBackOff(period time.Duration, attempts int) time.Duration {
+	backOff := period
+	for i := 1; i < attempts && backOff < reEnrichMaxBackOff; i++ {
+		backOff *= 2
+	}
+	if backOff > reEnrichMaxBackOff {
+		backOff = reEnrichMaxBackOff
+	}
+	return backOff
+}
+
+// reEnrich fetches missing data of a stored event and updates it. It reports whether the event is complete now,
+// and if not, whether it is worth retrying: events of devices with polling disabled are not.
+func reEnrich(ctx context.Context, repo repository.Connector, cache repository.Cache, cfg *Config, m *flapdb.Model) (complete, retry bool) {
+
+	event := LinkEvent{
+		sid:           m.Sid,
+		ifIndex:       m.IfIndex,
+		ifAdminStatus: m.IfAdminStatus,
+		ifOperStatus:  m.IfOperStatus,
+		trapType:      m.TrapType,
+		instance:      m.Instance,
+		ipAddress:     m.IpAddress,
+		time:          m.Time,
+		timeTicks:     m.TimeTicks,
+		hostName:      m.HostName,
+		hostSource:    m.HostNameSource,
+		ifName:        m.IfName,
+		ifAlias:       m.IfAlias,
+		lagIfIndex:    m.LagIfIndex,
+		lagIfName:     m.LagIfName,
+		parentIfIndex: m.ParentIfIndex,
+		parentSid:     m.ParentSid,
+		ifDescription: m.IfDescription,
+		peer:          m.Peer,
+		repo:          repo,
+		cache:         cache,
+		community:     cfg.Community,
+		config:        cfg,
+	}
+	event.FillInventory()
+
+	// The event was enriched from everything but the device already
+	if event.noPolling {
+		return false, false
+	}
+
+	hostName, ifName, ifAlias := event.hostName, event.ifName, event.ifAlias
+	event.FetchMissingData(ctx)
+
+	if event.hostName == hostName && event.ifName == ifName && event.ifAlias == ifAlias {
+		return false, true
+	}
+
+	if err := event.updateLinkEvent(); err != nil {
+		return false, true
+	}
+
+	log.Println(event.sid, "link event re-enriched")
+	complete = event.hostName != nil && event.ifName != nil && event.ifAlias != nil
+	return complete, !complete
+}
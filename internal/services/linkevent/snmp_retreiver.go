@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log"
 	"net"
+	"strings"
 	"sync"
 
 	g "github.com/gosnmp/gosnmp"
@@ -29,7 +30,8 @@ func doSNMPRequest(oid string, ip net.IP, community string) (pdu *g.SnmpPacket,
 	return g.Default.Get([]string{oid})
 }
 
-func getSNMPString(oid string, ip net.IP, community string) (val *string, err error) {
+// getSNMPString gets an OCTET STRING value decoded to UTF-8
+func getSNMPString(oid string, ip net.IP, community string, cs *Charsets) (val *string, err error) {
 
 	snmpSema.mx.Lock()
 	defer snmpSema.mx.Unlock()
@@ -41,8 +43,69 @@ func getSNMPString(oid string, ip net.IP, community string) (val *string, err er
 	value := pdu.Variables[0].Value
 	fromByte, ok := value.([]byte)
 	if ok {
-		s := string(fromByte)
+		s := cs.Decode(ip, fromByte)
 		return &s, nil
 	}
 	return nil, errors.New("received nil from the device")
 }
+
+// doSNMPBulkWalk walks with a client of its own, so a long walk of one device doesn't hold up
+// the Gets of the others, which share g.Default under snmpSema
+func doSNMPBulkWalk(oid string, ip net.IP, community string) (pdus []g.SnmpPDU, err error) {
+
+	c := &g.GoSNMP{
+		Target:             ip.String(),
+		Port:               g.Default.Port,
+		Transport:          g.Default.Transport,
+		Community:          community,
+		Version:            g.Default.Version,
+		Timeout:            g.Default.Timeout,
+		Retries:            g.Default.Retries,
+		ExponentialTimeout: g.Default.ExponentialTimeout,
+		MaxOids:            g.Default.MaxOids,
+	}
+
+	if err = c.Connect(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer c.Conn.Close()
+
+	return c.BulkWalkAll(oid)
+}
+
+// walkSNMPStrings walks an OID subtree and returns OCTET STRING values decoded to UTF-8 keyed by the OID suffix
+func walkSNMPStrings(oid string, ip net.IP, community string, cs *Charsets) (map[string]string, error) {
+
+	pdus, err := doSNMPBulkWalk(oid, ip, community)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(pdus))
+	for _, pdu := range pdus {
+		fromByte, ok := pdu.Value.([]byte)
+		if !ok {
+			continue
+		}
+		suffix := strings.TrimPrefix(strings.TrimPrefix(pdu.Name, oid), ".")
+		values[suffix] = cs.Decode(ip, fromByte)
+	}
+	return values, nil
+}
+
+// walkSNMPInts walks an OID subtree and returns integer values keyed by the OID suffix
+func walkSNMPInts(oid string, ip net.IP, community string) (map[string]int, error) {
+
+	pdus, err := doSNMPBulkWalk(oid, ip, community)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int, len(pdus))
+	for _, pdu := range pdus {
+		suffix := strings.TrimPrefix(strings.TrimPrefix(pdu.Name, oid), ".")
+		values[suffix] = int(g.ToBigInt(pdu.Value).Int64())
+	}
+	return values, nil
+}